@@ -818,6 +818,50 @@ func (h *FloatHistogram) Validate() error {
 	return nil
 }
 
+// customBucketsCountTolerance is the relative shortfall of the Count field
+// below the bucket total that ValidateCustomBuckets still accepts. Summing
+// float bucket counts accumulates rounding error, so an exact comparison
+// would produce false positives.
+const customBucketsCountTolerance = 1e-12
+
+// ValidateCustomBuckets checks the invariants specific to native histograms
+// with custom buckets, the float counterpart of Histogram.ValidateCustomBuckets:
+// the schema must be CustomBucketsSchema, the custom bounds must be strictly
+// ascending and cover the spans, no zero or negative buckets may be used,
+// bucket counts must not be negative, and the Count field must be at least
+// the number of observations found in the buckets. A NaN Sum is allowed, as
+// it only records that NaN values were observed. The Count comparison
+// tolerates a tiny relative shortfall to absorb floating point accumulation
+// error.
+func (h *FloatHistogram) ValidateCustomBuckets() error {
+	if !h.UsesCustomBuckets() {
+		return fmt.Errorf("schema %d is not the custom buckets schema", h.Schema)
+	}
+	if err := checkHistogramCustomBounds(h.CustomValues, h.PositiveSpans, len(h.PositiveBuckets)); err != nil {
+		return fmt.Errorf("custom buckets: %w", err)
+	}
+	if h.ZeroCount != 0 {
+		return fmt.Errorf("custom buckets: must have zero count of 0")
+	}
+	if h.ZeroThreshold != 0 {
+		return fmt.Errorf("custom buckets: must have zero threshold of 0")
+	}
+	if len(h.NegativeSpans) > 0 {
+		return fmt.Errorf("custom buckets: must not have negative spans")
+	}
+	if len(h.NegativeBuckets) > 0 {
+		return fmt.Errorf("custom buckets: must not have negative buckets")
+	}
+	var pCount float64
+	if err := checkHistogramBuckets(h.PositiveBuckets, &pCount, false); err != nil {
+		return fmt.Errorf("positive side: %w", err)
+	}
+	if pCount > h.Count && pCount-h.Count > customBucketsCountTolerance*pCount {
+		return fmt.Errorf("%g observations found in buckets, but the Count field is %g: %w", pCount, h.Count, ErrHistogramCountNotBigEnough)
+	}
+	return nil
+}
+
 // zeroCountForLargerThreshold returns what the histogram's zero count would be
 // if the ZeroThreshold had the provided larger (or equal) value. If the
 // provided value is less than the histogram's ZeroThreshold, the method panics.
@@ -3604,3 +3604,116 @@ func TestFloatHistogramReduceResolution(t *testing.T) {
 		require.Equal(t, tc.target, tc.origin)
 	}
 }
+
+func TestFloatHistogramValidateCustomBuckets(t *testing.T) {
+	validCustomHistogram := func() *FloatHistogram {
+		return &FloatHistogram{
+			Count:           6,
+			Sum:             19.4,
+			Schema:          CustomBucketsSchema,
+			PositiveSpans:   []Span{{Offset: 0, Length: 4}},
+			PositiveBuckets: []float64{1, 2, 1, 2},
+			CustomValues:    []float64{1, 2, 3},
+		}
+	}
+
+	tests := map[string]struct {
+		h      func() *FloatHistogram
+		errMsg string
+	}{
+		"valid custom buckets histogram": {
+			h: validCustomHistogram,
+		},
+		"valid with NaN sum": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.Sum = math.NaN()
+				return h
+			},
+		},
+		"valid with count exceeding bucket total": {
+			// Possible when NaN observations were made.
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.Count = 12
+				h.Sum = math.NaN()
+				return h
+			},
+		},
+		"valid with count short by float accumulation error": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.Count = 6 - 6e-13
+				return h
+			},
+		},
+		"rejects exponential schema": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.Schema = 0
+				h.CustomValues = nil
+				return h
+			},
+			errMsg: `schema 0 is not the custom buckets schema`,
+		},
+		"rejects non-ascending custom values": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.CustomValues = []float64{1, 3, 2}
+				return h
+			},
+			errMsg: `custom buckets: previous bound is 3.000000 and current is 2.000000: histogram custom bounds must be in strictly increasing order`,
+		},
+		"rejects span length not matching buckets": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.PositiveSpans = []Span{{Offset: 0, Length: 3}}
+				return h
+			},
+			errMsg: `custom buckets: spans need 3 buckets, have 4 buckets: histogram spans specify different number of buckets than provided`,
+		},
+		"rejects negative bucket count": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.PositiveBuckets = []float64{1, 2, -1, 2}
+				return h
+			},
+			errMsg: `positive side: bucket number 3 has observation count of -1: histogram has a bucket whose observation count is negative`,
+		},
+		"rejects count clearly smaller than bucket total": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.Count = 5
+				return h
+			},
+			errMsg: `6 observations found in buckets, but the Count field is 5: histogram's observation count should be at least the number of observations found in the buckets`,
+		},
+		"rejects non-zero zero count": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.ZeroCount = 1
+				return h
+			},
+			errMsg: `custom buckets: must have zero count of 0`,
+		},
+		"rejects negative buckets": {
+			h: func() *FloatHistogram {
+				h := validCustomHistogram()
+				h.NegativeSpans = []Span{{Offset: 0, Length: 1}}
+				h.NegativeBuckets = []float64{1}
+				return h
+			},
+			errMsg: `custom buckets: must not have negative spans`,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if err := tc.h().ValidateCustomBuckets(); tc.errMsg != "" {
+				require.EqualError(t, err, tc.errMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
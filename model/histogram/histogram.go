@@ -477,6 +477,42 @@ func (h *Histogram) Validate() error {
 	return nil
 }
 
+// ValidateCustomBuckets checks the invariants specific to native histograms
+// with custom buckets: the schema must be CustomBucketsSchema, the custom
+// bounds must be strictly ascending and cover the spans, no zero or negative
+// buckets may be used, bucket counts must not be negative, and the Count
+// field must be at least the number of observations found in the buckets.
+// Unlike Validate, it does not require Count to exactly equal the bucket
+// total, so histograms whose Count includes NaN observations also pass.
+func (h *Histogram) ValidateCustomBuckets() error {
+	if !h.UsesCustomBuckets() {
+		return fmt.Errorf("schema %d is not the custom buckets schema", h.Schema)
+	}
+	if err := checkHistogramCustomBounds(h.CustomValues, h.PositiveSpans, len(h.PositiveBuckets)); err != nil {
+		return fmt.Errorf("custom buckets: %w", err)
+	}
+	if h.ZeroCount != 0 {
+		return fmt.Errorf("custom buckets: must have zero count of 0")
+	}
+	if h.ZeroThreshold != 0 {
+		return fmt.Errorf("custom buckets: must have zero threshold of 0")
+	}
+	if len(h.NegativeSpans) > 0 {
+		return fmt.Errorf("custom buckets: must not have negative spans")
+	}
+	if len(h.NegativeBuckets) > 0 {
+		return fmt.Errorf("custom buckets: must not have negative buckets")
+	}
+	var pCount uint64
+	if err := checkHistogramBuckets(h.PositiveBuckets, &pCount, true); err != nil {
+		return fmt.Errorf("positive side: %w", err)
+	}
+	if pCount > h.Count {
+		return fmt.Errorf("%d observations found in buckets, but the Count field is %d: %w", pCount, h.Count, ErrHistogramCountNotBigEnough)
+	}
+	return nil
+}
+
 type regularBucketIterator struct {
 	baseBucketIterator[uint64, int64]
 }
@@ -1588,6 +1588,105 @@ func TestHistogramValidation(t *testing.T) {
 	}
 }
 
+func TestHistogramValidateCustomBuckets(t *testing.T) {
+	validCustomHistogram := func() *Histogram {
+		return &Histogram{
+			Count:           6,
+			Sum:             19.4,
+			Schema:          CustomBucketsSchema,
+			PositiveSpans:   []Span{{Offset: 0, Length: 4}},
+			PositiveBuckets: []int64{1, 1, -1, 1},
+			CustomValues:    []float64{1, 2, 3},
+		}
+	}
+
+	tests := map[string]struct {
+		h      func() *Histogram
+		errMsg string
+	}{
+		"valid custom buckets histogram": {
+			h: validCustomHistogram,
+		},
+		"valid with count exceeding bucket total": {
+			// Possible when NaN observations were made.
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.Count = 12
+				h.Sum = math.NaN()
+				return h
+			},
+		},
+		"rejects exponential schema": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.Schema = 0
+				h.CustomValues = nil
+				return h
+			},
+			errMsg: `schema 0 is not the custom buckets schema`,
+		},
+		"rejects non-ascending custom values": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.CustomValues = []float64{1, 3, 2}
+				return h
+			},
+			errMsg: `custom buckets: previous bound is 3.000000 and current is 2.000000: histogram custom bounds must be in strictly increasing order`,
+		},
+		"rejects span length not matching buckets": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.PositiveSpans = []Span{{Offset: 0, Length: 3}}
+				return h
+			},
+			errMsg: `custom buckets: spans need 3 buckets, have 4 buckets: histogram spans specify different number of buckets than provided`,
+		},
+		"rejects negative bucket count": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.PositiveBuckets = []int64{1, 1, -3, 1}
+				return h
+			},
+			errMsg: `positive side: bucket number 3 has observation count of -1: histogram has a bucket whose observation count is negative`,
+		},
+		"rejects count smaller than bucket total": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.Count = 5
+				return h
+			},
+			errMsg: `6 observations found in buckets, but the Count field is 5: histogram's observation count should be at least the number of observations found in the buckets`,
+		},
+		"rejects non-zero zero count": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.ZeroCount = 1
+				return h
+			},
+			errMsg: `custom buckets: must have zero count of 0`,
+		},
+		"rejects negative buckets": {
+			h: func() *Histogram {
+				h := validCustomHistogram()
+				h.NegativeSpans = []Span{{Offset: 0, Length: 1}}
+				h.NegativeBuckets = []int64{1}
+				return h
+			},
+			errMsg: `custom buckets: must not have negative spans`,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if err := tc.h().ValidateCustomBuckets(); tc.errMsg != "" {
+				require.EqualError(t, err, tc.errMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 func BenchmarkHistogramValidation(b *testing.B) {
 	histograms := GenerateBigTestHistograms(b.N, 500)
 	b.ResetTimer()
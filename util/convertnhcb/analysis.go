@@ -0,0 +1,250 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// Buckets returns an iterator over the logical classic buckets of a
+// custom-buckets histogram as (upperBound, cumulativeCount) pairs: each
+// finite bound from CustomValues in order, followed by the +Inf bucket whose
+// cumulative count equals Count. Buckets that span compaction dropped are
+// yielded with an unchanged cumulative count. The iterator has the
+// range-over-func shape so callers on newer language versions can range over
+// it directly.
+func Buckets(h *histogram.Histogram) func(yield func(upperBound, count float64) bool) {
+	counts := make([]float64, len(h.CustomValues)+1)
+	var cur int64
+	idx, bi := -1, 0
+	for _, span := range h.PositiveSpans {
+		idx += int(span.Offset)
+		for i := uint32(0); i < span.Length; i++ {
+			idx++
+			cur += h.PositiveBuckets[bi]
+			bi++
+			if idx >= 0 && idx < len(counts) {
+				counts[idx] = float64(cur)
+			}
+		}
+	}
+	return func(yield func(upperBound, count float64) bool) {
+		cum := 0.0
+		for i, le := range h.CustomValues {
+			cum += counts[i]
+			if !yield(le, cum) {
+				return
+			}
+		}
+		yield(math.Inf(1), float64(h.Count))
+	}
+}
+
+// BucketsFloat is the float-histogram variant of Buckets.
+func BucketsFloat(fh *histogram.FloatHistogram) func(yield func(upperBound, count float64) bool) {
+	counts := make([]float64, len(fh.CustomValues)+1)
+	idx, bi := -1, 0
+	for _, span := range fh.PositiveSpans {
+		idx += int(span.Offset)
+		for i := uint32(0); i < span.Length; i++ {
+			idx++
+			if idx >= 0 && idx < len(counts) {
+				counts[idx] = fh.PositiveBuckets[bi]
+			}
+			bi++
+		}
+	}
+	return func(yield func(upperBound, count float64) bool) {
+		cum := 0.0
+		for i, le := range fh.CustomValues {
+			cum += counts[i]
+			if !yield(le, cum) {
+				return
+			}
+		}
+		yield(math.Inf(1), fh.Count)
+	}
+}
+
+// CumulativeCounts returns the cumulative count at each custom bound by
+// walking the histogram's own bucket iterator — the one TSDB reads decode
+// through — instead of the raw PositiveBuckets the way Buckets does.
+// Agreement between the two is exactly the cross-check conversion
+// verification wants: the span encoding decodes back to the expected classic
+// cumulative values. The +Inf overflow is omitted since its cumulative count
+// is always Count. Buckets dropped by compaction are absent from the
+// iteration and leave their bound's cumulative count unchanged.
+func CumulativeCounts(h *histogram.Histogram) []float64 {
+	cumulative := make([]float64, len(h.CustomValues))
+	cum := 0.0
+	i := 0
+	it := h.PositiveBucketIterator()
+	for it.Next() && i < len(cumulative) {
+		b := it.At()
+		for i < len(h.CustomValues) && h.CustomValues[i] < b.Upper {
+			cumulative[i] = cum
+			i++
+		}
+		cum += float64(b.Count)
+		if i < len(h.CustomValues) && b.Upper == h.CustomValues[i] {
+			cumulative[i] = cum
+			i++
+		}
+	}
+	for ; i < len(cumulative); i++ {
+		cumulative[i] = cum
+	}
+	return cumulative
+}
+
+// Quantile estimates the q-quantile of a converted custom-buckets histogram
+// with the same linear interpolation the classic histogram_quantile applies
+// to bucket series, so conversion tests can compare the two directly. As
+// there, a quantile falling into the open-ended +Inf bucket returns the top
+// finite bound, q below 0 returns -Inf, q above 1 returns +Inf, and an empty
+// histogram (or NaN q) returns NaN.
+func Quantile(q float64, h *histogram.Histogram) float64 {
+	switch {
+	case math.IsNaN(q):
+		return math.NaN()
+	case q < 0:
+		return math.Inf(-1)
+	case q > 1:
+		return math.Inf(1)
+	}
+	if h.Count == 0 {
+		return math.NaN()
+	}
+	if len(h.CustomValues) == 0 {
+		// Only the open-ended bucket exists; there is no finite bound to
+		// report.
+		return math.Inf(1)
+	}
+	rank := q * float64(h.Count)
+	var (
+		bucketStart, bucketEnd float64
+		prevCum, foundCum      float64
+		idx                    int
+		foundIdx               = -1
+	)
+	Buckets(h)(func(upperBound, count float64) bool {
+		if foundIdx < 0 && !math.IsInf(upperBound, 1) && count >= rank {
+			bucketEnd = upperBound
+			foundCum = count
+			foundIdx = idx
+			return false
+		}
+		prevCum = count
+		bucketStart = upperBound
+		idx++
+		return true
+	})
+	if foundIdx < 0 {
+		// The quantile falls into the +Inf bucket.
+		return h.CustomValues[len(h.CustomValues)-1]
+	}
+	if foundIdx == 0 {
+		if bucketEnd <= 0 {
+			// First bucket with a non-positive upper bound: there is no
+			// defined interpolation start, as in the classic implementation.
+			return bucketEnd
+		}
+		bucketStart = 0
+	}
+	return bucketStart + (bucketEnd-bucketStart)*((rank-prevCum)/(foundCum-prevCum))
+}
+
+// WidestBucketRatio finds the adjacent bound pair with the largest
+// multiplicative gap, reporting the index of its lower bound and the ratio
+// between the two bounds. It flags regions where a custom layout is too
+// coarse. A trailing +Inf terminator is ignored, and when any considered
+// bound is non-positive — where ratios are meaningless — the additive gap is
+// used instead, with the gap returned in place of the ratio. Fewer than two
+// finite bounds yield (-1, 0).
+func WidestBucketRatio(bounds []float64) (lowIdx int, ratio float64) {
+	if len(bounds) > 0 && math.IsInf(bounds[len(bounds)-1], 1) {
+		bounds = bounds[:len(bounds)-1]
+	}
+	if len(bounds) < 2 {
+		return -1, 0
+	}
+	additive := false
+	for _, b := range bounds {
+		if b <= 0 {
+			additive = true
+			break
+		}
+	}
+	lowIdx = 0
+	for i := 0; i+1 < len(bounds); i++ {
+		gap := bounds[i+1] / bounds[i]
+		if additive {
+			gap = bounds[i+1] - bounds[i]
+		}
+		if gap > ratio {
+			lowIdx, ratio = i, gap
+		}
+	}
+	return lowIdx, ratio
+}
+
+// FiniteCount returns the number of observations that fell within the
+// explicit finite buckets, i.e. the cumulative count up to the last finite
+// bound. The difference to Count is the overflow that only the implicit +Inf
+// bucket caught. A histogram with no finite bounds yields 0.
+func FiniteCount(h *histogram.Histogram) float64 {
+	finite := 0.0
+	Buckets(h)(func(upperBound, count float64) bool {
+		if math.IsInf(upperBound, 1) {
+			return false
+		}
+		finite = count
+		return true
+	})
+	return finite
+}
+
+// FractionInFirstBucket returns the fraction of observations that fell at or
+// below the smallest custom bound, i.e. the first bucket's share of the total
+// count. An empty histogram yields 0.
+func FractionInFirstBucket(h *histogram.Histogram) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(firstBucketCount(h.PositiveSpans, h.PositiveBuckets)) / float64(h.Count)
+}
+
+// FractionInFirstBucketFloat is the float-histogram variant of
+// FractionInFirstBucket.
+func FractionInFirstBucketFloat(fh *histogram.FloatHistogram) float64 {
+	if fh.Count == 0 {
+		return 0
+	}
+	if len(fh.PositiveSpans) == 0 || fh.PositiveSpans[0].Offset != 0 || len(fh.PositiveBuckets) == 0 {
+		return 0
+	}
+	return fh.PositiveBuckets[0] / fh.Count
+}
+
+// firstBucketCount returns the absolute count of the bucket at offset 0. A
+// compacted histogram may have dropped that bucket entirely, which means it
+// was empty.
+func firstBucketCount(spans []histogram.Span, buckets []int64) int64 {
+	if len(spans) == 0 || spans[0].Offset != 0 || len(buckets) == 0 {
+		return 0
+	}
+	return buckets[0]
+}
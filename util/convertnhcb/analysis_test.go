@@ -0,0 +1,201 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuckets(t *testing.T) {
+	type pair struct{ le, count float64 }
+	collect := func(iter func(yield func(upperBound, count float64) bool)) []pair {
+		var pairs []pair
+		iter(func(upperBound, count float64) bool {
+			pairs = append(pairs, pair{upperBound, count})
+			return true
+		})
+		return pairs
+	}
+
+	t.Run("integer histogram", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		require.Equal(t, []pair{{1, 10}, {2, 15}, {3, 25}, {math.Inf(1), 25}}, collect(Buckets(h)))
+	})
+
+	t.Run("compacted histogram keeps logical buckets", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 0, 2: 5, 3: 5, math.Inf(1): 5},
+			Count:        5,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, 2, 3, math.Inf(1)})
+		h, _ := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Equal(t, []pair{{1, 0}, {2, 5}, {3, 5}, {math.Inf(1), 5}}, collect(Buckets(h)))
+	})
+
+	t.Run("float histogram", func(t *testing.T) {
+		_, fh, err := Convert(IntegerHistogramTest, &Options{Scale: 0.5})
+		require.NoError(t, err)
+		require.Equal(t, []pair{{1, 5}, {2, 7.5}, {3, 12.5}, {math.Inf(1), 12.5}}, collect(BucketsFloat(fh)))
+	})
+
+	t.Run("early stop", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		var n int
+		Buckets(h)(func(upperBound, count float64) bool {
+			n++
+			return n < 2
+		})
+		require.Equal(t, 2, n)
+	})
+}
+
+func TestQuantile(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+
+	// The median's rank of 12.5 falls into the (1, 2] bucket holding the
+	// cumulative range (10, 15], interpolated to 1.5.
+	require.Equal(t, 1.5, Quantile(0.5, h))
+	// Rank 5 sits inside the first bucket, interpolated from 0.
+	require.Equal(t, 0.5, Quantile(0.2, h))
+	// The maximum is in the last finite bucket.
+	require.Equal(t, 3.0, Quantile(1, h))
+
+	t.Run("edge cases", func(t *testing.T) {
+		require.Equal(t, math.Inf(-1), Quantile(-0.1, h))
+		require.Equal(t, math.Inf(1), Quantile(1.1, h))
+		require.True(t, math.IsNaN(Quantile(math.NaN(), h)))
+
+		empty, _, err := Convert(TempHistogram{BucketCounts: map[float64]float64{1: 0, math.Inf(1): 0}}, nil)
+		require.NoError(t, err)
+		require.True(t, math.IsNaN(Quantile(0.5, empty)))
+	})
+
+	t.Run("quantile in Inf bucket returns top finite bound", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 20},
+			Count:        20,
+		}
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, Quantile(0.9, h))
+	})
+}
+
+func TestWidestBucketRatio(t *testing.T) {
+	t.Run("exponential set has a constant ratio", func(t *testing.T) {
+		lowIdx, ratio := WidestBucketRatio([]float64{1, 2, 4, 8, math.Inf(1)})
+		require.Equal(t, 0, lowIdx)
+		require.Equal(t, 2.0, ratio)
+	})
+
+	t.Run("lopsided set identifies the gap", func(t *testing.T) {
+		lowIdx, ratio := WidestBucketRatio([]float64{1, 2, 100, math.Inf(1)})
+		require.Equal(t, 1, lowIdx)
+		require.Equal(t, 50.0, ratio)
+	})
+
+	t.Run("non-positive bounds fall back to additive gaps", func(t *testing.T) {
+		lowIdx, gap := WidestBucketRatio([]float64{-3, -1, 4, math.Inf(1)})
+		require.Equal(t, 1, lowIdx)
+		require.Equal(t, 5.0, gap)
+	})
+
+	t.Run("too few bounds", func(t *testing.T) {
+		lowIdx, ratio := WidestBucketRatio([]float64{1, math.Inf(1)})
+		require.Equal(t, -1, lowIdx)
+		require.Equal(t, 0.0, ratio)
+	})
+}
+
+func TestFiniteCount(t *testing.T) {
+	t.Run("with overflow", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, 2: 15, math.Inf(1): 20},
+			Count:        20,
+		}
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, 15.0, FiniteCount(h))
+	})
+
+	t.Run("without overflow", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		require.Equal(t, 25.0, FiniteCount(h))
+		require.Equal(t, float64(h.Count), FiniteCount(h))
+	})
+
+	t.Run("no finite bounds", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{math.Inf(1): 5},
+			Count:        5,
+		}
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, 0.0, FiniteCount(h))
+	})
+}
+
+func TestFractionInFirstBucket(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, 10.0/25.0, FractionInFirstBucket(h))
+
+	_, fh, err := Convert(IntegerHistogramTest, &Options{Scale: 0.5})
+	require.NoError(t, err)
+	require.Equal(t, 5.0/12.5, FractionInFirstBucketFloat(fh))
+
+	t.Run("empty histogram", func(t *testing.T) {
+		th := TempHistogram{BucketCounts: map[float64]float64{1: 0, math.Inf(1): 0}}
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, 0.0, FractionInFirstBucket(h))
+	})
+
+	t.Run("empty first bucket after compaction", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 0, 2: 5, math.Inf(1): 5},
+			Count:        5,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, 2, math.Inf(1)})
+		h, _ := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Equal(t, 0.0, FractionInFirstBucket(h))
+	})
+}
+
+func TestCumulativeCounts(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, []float64{10, 15, 25}, CumulativeCounts(h))
+
+	t.Run("compacted spans decode to the same cumulatives", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 5, 2: 5, 3: 5, 4: 12, math.Inf(1): 12},
+			Count:        12,
+		}
+		th.SetSum(30)
+		dense, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		compact, _, err := Convert(th, &Options{Compact: true})
+		require.NoError(t, err)
+		require.Equal(t, []float64{5, 5, 5, 12}, CumulativeCounts(dense))
+		require.Equal(t, CumulativeCounts(dense), CumulativeCounts(compact))
+	})
+}
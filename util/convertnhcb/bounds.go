@@ -0,0 +1,306 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// schemaDetectionTolerance is how far (in bucket index space) a bound may be
+// from an exact standard schema boundary and still be considered a match.
+// It absorbs the error introduced by float64 round-trips through text
+// exposition formats.
+const schemaDetectionTolerance = 1e-8
+
+// DetectSchema checks whether the given upper bounds all lie on the bucket
+// boundaries of one of Prometheus's standard exponential schemas, within a
+// small tolerance. If so, it returns the coarsest matching schema and false,
+// meaning a standard native histogram could represent the same buckets more
+// compactly. Otherwise it returns histogram.CustomBucketsSchema and true,
+// meaning the bounds genuinely require custom buckets. A trailing +Inf bound
+// is ignored since it is implicit in both representations.
+func DetectSchema(upperBounds []float64) (schema int32, isCustom bool) {
+	bounds := upperBounds
+	if len(bounds) > 0 && bounds[len(bounds)-1] == math.Inf(1) {
+		bounds = bounds[:len(bounds)-1]
+	}
+	if len(bounds) == 0 {
+		return histogram.CustomBucketsSchema, true
+	}
+	for _, b := range bounds {
+		if b <= 0 || math.IsInf(b, 1) || math.IsNaN(b) {
+			// Standard exponential schemas only cover positive finite bounds.
+			return histogram.CustomBucketsSchema, true
+		}
+	}
+	for s := histogram.ExponentialSchemaMin; s <= histogram.ExponentialSchemaMax; s++ {
+		if boundsMatchSchema(bounds, s) {
+			return s, false
+		}
+	}
+	return histogram.CustomBucketsSchema, true
+}
+
+// SchemaRegion describes one contiguous run of bounds that either all lie on
+// a standard exponential schema's bucket boundaries or are irregular.
+type SchemaRegion struct {
+	// Start and End delimit the region as a half-open index range into the
+	// bounds passed to SchemaRegions.
+	Start, End int
+	// Schema is the coarsest standard schema covering a regular region, or
+	// histogram.CustomBucketsSchema for an irregular one.
+	Schema int32
+	// Custom reports that no standard schema covers the region.
+	Custom bool
+}
+
+// SchemaRegions segments the bound set into contiguous runs that a standard
+// exponential schema could represent versus irregular runs, extending
+// DetectSchema to layouts that are exponential in part of the range and, say,
+// linear elsewhere. Advisory tooling uses it to tell users which part of their
+// histogram a standard native schema could cover. The segmentation is greedy:
+// at each position the longest run matching some standard schema wins, and a
+// run must span at least two bounds to count as regular — a single bound in
+// isolation matches too many schemas to be meaningful.
+func SchemaRegions(bounds []float64) []SchemaRegion {
+	var regions []SchemaRegion
+	customStart := -1
+	i := 0
+	for i < len(bounds) {
+		end := i
+		var schema int32
+		for j := len(bounds); j >= i+2; j-- {
+			if s, isCustom := DetectSchema(bounds[i:j]); !isCustom {
+				end, schema = j, s
+				break
+			}
+		}
+		if end > i {
+			if customStart >= 0 {
+				regions = append(regions, SchemaRegion{Start: customStart, End: i, Schema: histogram.CustomBucketsSchema, Custom: true})
+				customStart = -1
+			}
+			regions = append(regions, SchemaRegion{Start: i, End: end, Schema: schema})
+			i = end
+			continue
+		}
+		if customStart < 0 {
+			customStart = i
+		}
+		i++
+	}
+	if customStart >= 0 {
+		regions = append(regions, SchemaRegion{Start: customStart, End: len(bounds), Schema: histogram.CustomBucketsSchema, Custom: true})
+	}
+	return regions
+}
+
+// BoundsCompatible reports whether a sample with the incoming custom bounds
+// can continue a series stored with the given bounds, i.e. whether the two
+// bound sets are identical. If they are not, the index of the first
+// divergence is returned so ingestion can log it and cut a new chunk; for a
+// pure length mismatch that is the length of the shorter set. On a match the
+// index is -1.
+func BoundsCompatible(stored, incoming []float64) (bool, int) {
+	n := len(stored)
+	if len(incoming) < n {
+		n = len(incoming)
+	}
+	for i := 0; i < n; i++ {
+		if stored[i] != incoming[i] {
+			return false, i
+		}
+	}
+	if len(stored) != len(incoming) {
+		return false, n
+	}
+	return true, -1
+}
+
+// ParseUpperBound parses the value of a classic histogram's le label into the
+// upper bound it denotes, using the same rules Prometheus applies elsewhere:
+// regular and scientific float notation as well as the infinity spellings
+// accepted by strconv ("+Inf", "Inf", "inf", ...). The spellings beyond the
+// canonical "+Inf" are deliberately tolerated: OpenMetrics producers in the
+// wild emit "Inf" and case variants, and dropping their otherwise-valid
+// histograms over the spelling would be needlessly strict. All of them
+// normalize to math.Inf(1). NaN is not a meaningful bucket boundary and is
+// rejected along with unparseable input.
+func ParseUpperBound(le string) (float64, error) {
+	bound, err := strconv.ParseFloat(le, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid le value %q: %w", le, err)
+	}
+	if math.IsNaN(bound) {
+		return 0, fmt.Errorf("invalid le value %q: NaN is not a bucket boundary", le)
+	}
+	return bound, nil
+}
+
+// CanonicalizeBounds rounds each finite bound to the given number of
+// significant digits, so that the same logical bucket layout scraped from
+// many targets becomes bit-identical despite last-digit float representation
+// differences. Infinite bounds are passed through untouched. A fresh slice is
+// returned; the input is not modified. Canonicalized bounds cluster better in
+// a BoundsInterner and under BoundsCompatible.
+func CanonicalizeBounds(bounds []float64, decimals int) []float64 {
+	canonical := make([]float64, len(bounds))
+	for i, b := range bounds {
+		if math.IsInf(b, 0) {
+			canonical[i] = b
+			continue
+		}
+		rounded, err := strconv.ParseFloat(strconv.FormatFloat(b, 'g', decimals, 64), 64)
+		if err != nil {
+			// FormatFloat output always parses back; keep the original bound
+			// if it somehow does not.
+			rounded = b
+		}
+		canonical[i] = rounded
+	}
+	return canonical
+}
+
+// BoundsOptions configures PrepareBounds. The zero value sorts and dedups
+// without rounding.
+type BoundsOptions struct {
+	// Decimals, when non-zero, rounds every finite bound to that many
+	// significant digits before dedup, as in CanonicalizeBounds. Bounds that
+	// only differ in digits beyond the precision merge into one bucket.
+	Decimals int
+}
+
+// PrepareBounds turns a raw set of upper bounds into a canonical one in a
+// single pass: it optionally rounds each bound, sorts, and merges bounds that
+// became identical. Alongside the canonical bounds it returns a mapping from
+// each raw index to the canonical index its bound ended up at, which is what
+// lets the caller fold the corresponding bucket counts correctly — plain
+// dedup loses that association. NaN bounds are rejected. The input is not
+// modified.
+func PrepareBounds(raw []float64, opts BoundsOptions) ([]float64, []int, error) {
+	for i, b := range raw {
+		if math.IsNaN(b) {
+			return nil, nil, fmt.Errorf("bound at index %d: NaN is not a bucket boundary", i)
+		}
+	}
+	canonical := raw
+	if opts.Decimals != 0 {
+		canonical = CanonicalizeBounds(raw, opts.Decimals)
+	}
+	bounds := make([]float64, len(canonical))
+	copy(bounds, canonical)
+	sort.Float64s(bounds)
+	bounds = slices.Compact(bounds)
+	mapping := make([]int, len(canonical))
+	for i, b := range canonical {
+		mapping[i] = sort.SearchFloat64s(bounds, b)
+	}
+	return bounds, mapping, nil
+}
+
+// BoundsHash returns a deterministic hash of the bound set, suitable as a
+// cache key for interners and base caches. It hashes the float64 bit patterns
+// in order, so it is order-sensitive, distinguishes +Inf from any finite
+// bound, and is stable across runs and process restarts.
+func BoundsHash(bounds []float64) uint64 {
+	return boundsHash(bounds)
+}
+
+// ParseCount parses a sample value arriving as a string token, using the
+// same float parsing rules as ParseUpperBound. It additionally reports
+// whether the parsed value is non-integral (including NaN and infinities),
+// so callers accumulating into a TempHistogram know to set HasFloat.
+func ParseCount(s string) (value float64, isFloat bool, err error) {
+	value, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid count value %q: %w", s, err)
+	}
+	isFloat = math.IsNaN(value) || math.IsInf(value, 0) || value != math.Round(value)
+	return value, isFloat, nil
+}
+
+// FormatBounds renders the bucket boundaries of a custom-buckets histogram
+// as the familiar classic le label strings derived from CustomValues, with
+// the implicit +Inf terminator appended. The values are formatted the same
+// way classic bucket series render their le labels, so integer-valued bounds
+// come out without trailing zeros.
+func FormatBounds(h *histogram.Histogram) []string {
+	return FormatBoundsWith(h, nil)
+}
+
+// FormatBoundsWith is FormatBounds with a caller-supplied formatter applied
+// to the finite bounds, for bridges whose label conventions want e.g. fixed
+// precision or scientific notation. A nil formatter falls back to the
+// canonical Prometheus formatting, making the call equivalent to
+// FormatBounds. The +Inf terminator is always rendered as "+Inf" regardless
+// of the formatter.
+func FormatBoundsWith(h *histogram.Histogram, format func(float64) string) []string {
+	if format == nil {
+		format = formatBound
+	}
+	bounds := make([]string, 0, len(h.CustomValues)+1)
+	for _, b := range h.CustomValues {
+		bounds = append(bounds, format(b))
+	}
+	return append(bounds, "+Inf")
+}
+
+// FormatBoundsWithStrings is FormatBounds with the original le strings
+// recorded during collection (see TempHistogram.SetBucketString and
+// ConvertStats.LeStrings) substituted where available, enabling byte-exact
+// NHCB-to-classic round trips. Bounds without a recorded string fall back to
+// the standard formatting, including the +Inf terminator.
+func FormatBoundsWithStrings(h *histogram.Histogram, original map[float64]string) []string {
+	bounds := make([]string, 0, len(h.CustomValues)+1)
+	for _, b := range h.CustomValues {
+		if s, ok := original[b]; ok {
+			bounds = append(bounds, s)
+			continue
+		}
+		bounds = append(bounds, formatBound(b))
+	}
+	if s, ok := original[math.Inf(1)]; ok {
+		return append(bounds, s)
+	}
+	return append(bounds, "+Inf")
+}
+
+func formatBound(b float64) string {
+	switch {
+	case math.IsInf(b, 1):
+		return "+Inf"
+	case math.IsInf(b, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(b, 'g', -1, 64)
+}
+
+// boundsMatchSchema reports whether every bound equals 2^(i*2^-schema) for
+// some integer bucket index i, within schemaDetectionTolerance.
+func boundsMatchSchema(bounds []float64, schema int32) bool {
+	factor := math.Ldexp(1, int(schema))
+	for _, b := range bounds {
+		idx := math.Log2(b) * factor
+		if math.Abs(idx-math.Round(idx)) > schemaDetectionTolerance {
+			return false
+		}
+	}
+	return true
+}
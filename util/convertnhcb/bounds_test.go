@@ -0,0 +1,265 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+func TestFormatBounds(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "2", "3", "+Inf"}, FormatBounds(h))
+
+	th := TempHistogram{BucketCounts: map[float64]float64{0.25: 1, 10: 2, math.Inf(1): 2}}
+	h, _, err = Convert(th, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.25", "10", "+Inf"}, FormatBounds(h))
+}
+
+func TestFormatBoundsWith(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+
+	fixed := func(b float64) string { return fmt.Sprintf("%.2f", b) }
+	require.Equal(t, []string{"1.00", "2.00", "3.00", "+Inf"}, FormatBoundsWith(h, fixed))
+
+	// A nil formatter is the canonical Prometheus formatting.
+	require.Equal(t, FormatBounds(h), FormatBoundsWith(h, nil))
+}
+
+func TestBoundsCompatible(t *testing.T) {
+	stored := []float64{1, 2, 3}
+
+	ok, idx := BoundsCompatible(stored, []float64{1, 2, 3})
+	require.True(t, ok)
+	require.Equal(t, -1, idx)
+
+	ok, idx = BoundsCompatible(stored, []float64{1, 2})
+	require.False(t, ok)
+	require.Equal(t, 2, idx)
+
+	ok, idx = BoundsCompatible(stored, []float64{1, 2.5, 3})
+	require.False(t, ok)
+	require.Equal(t, 1, idx)
+
+	// Two empty bound sets are trivially compatible.
+	ok, idx = BoundsCompatible(nil, nil)
+	require.True(t, ok)
+	require.Equal(t, -1, idx)
+}
+
+func TestParseUpperBound(t *testing.T) {
+	for _, tc := range []struct {
+		le       string
+		expected float64
+	}{
+		{le: "+Inf", expected: math.Inf(1)},
+		// Lenient OpenMetrics producers spell infinity in various ways; all
+		// of them must normalize to math.Inf(1).
+		{le: "Inf", expected: math.Inf(1)},
+		{le: "inf", expected: math.Inf(1)},
+		{le: "INF", expected: math.Inf(1)},
+		{le: "+inf", expected: math.Inf(1)},
+		{le: "-Inf", expected: math.Inf(-1)},
+		{le: "0.5", expected: 0.5},
+		{le: "1e3", expected: 1000},
+		{le: "1E3", expected: 1000},
+		{le: "-2", expected: -2},
+	} {
+		bound, err := ParseUpperBound(tc.le)
+		require.NoError(t, err, "le=%q", tc.le)
+		require.Equal(t, tc.expected, bound, "le=%q", tc.le)
+	}
+
+	for _, le := range []string{"", "abc", "1.2.3", "NaN"} {
+		_, err := ParseUpperBound(le)
+		require.Error(t, err, "le=%q", le)
+	}
+}
+
+func TestCanonicalizeBounds(t *testing.T) {
+	a := []float64{0.1, 0.25, 1.0 / 3.0, math.Inf(1)}
+	b := []float64{0.1 + 1e-16, 0.25, 0.333333333333, math.Inf(1)}
+
+	ca := CanonicalizeBounds(a, 9)
+	cb := CanonicalizeBounds(b, 9)
+	require.Equal(t, ca, cb)
+	require.Equal(t, math.Inf(1), ca[3])
+
+	// Differences within the rounding precision are preserved.
+	require.NotEqual(t,
+		CanonicalizeBounds([]float64{0.123456789}, 9),
+		CanonicalizeBounds([]float64{0.123456788}, 9))
+
+	// The input is left untouched.
+	require.Equal(t, 1.0/3.0, a[2])
+}
+
+func TestBoundsHash(t *testing.T) {
+	a := []float64{1, 2, 3, math.Inf(1)}
+	b := []float64{1, 2, 3, math.Inf(1)}
+	require.Equal(t, BoundsHash(a), BoundsHash(b))
+
+	// A single differing bound changes the hash.
+	require.NotEqual(t, BoundsHash(a), BoundsHash([]float64{1, 2, 4, math.Inf(1)}))
+	// Dropping the +Inf terminator changes it too.
+	require.NotEqual(t, BoundsHash(a), BoundsHash([]float64{1, 2, 3}))
+	// Order matters.
+	require.NotEqual(t, BoundsHash([]float64{1, 2}), BoundsHash([]float64{2, 1}))
+}
+
+func TestPrepareBounds(t *testing.T) {
+	t.Run("rounded duplicate merges with index mapping", func(t *testing.T) {
+		raw := []float64{0.25, 0.1 + 1e-16, 0.1, math.Inf(1)}
+		bounds, mapping, err := PrepareBounds(raw, BoundsOptions{Decimals: 9})
+		require.NoError(t, err)
+		require.Equal(t, []float64{0.1, 0.25, math.Inf(1)}, bounds)
+		// Both spellings of 0.1 map to the same canonical bucket.
+		require.Equal(t, []int{1, 0, 0, 2}, mapping)
+	})
+
+	t.Run("no rounding keeps distinct bounds", func(t *testing.T) {
+		raw := []float64{2, 1, 1, math.Inf(1)}
+		bounds, mapping, err := PrepareBounds(raw, BoundsOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []float64{1, 2, math.Inf(1)}, bounds)
+		require.Equal(t, []int{1, 0, 0, 2}, mapping)
+	})
+
+	t.Run("NaN bound is rejected", func(t *testing.T) {
+		_, _, err := PrepareBounds([]float64{1, math.NaN()}, BoundsOptions{})
+		require.Error(t, err)
+	})
+}
+
+func TestSchemaRegions(t *testing.T) {
+	t.Run("purely exponential set is one region", func(t *testing.T) {
+		regions := SchemaRegions([]float64{1, 2, 4, 8})
+		require.Equal(t, []SchemaRegion{
+			{Start: 0, End: 4, Schema: 0},
+		}, regions)
+	})
+
+	t.Run("mixed set splits into regions", func(t *testing.T) {
+		// Exponential up to 2, then a linear-ish tail.
+		regions := SchemaRegions([]float64{0.25, 0.5, 1, 2, 3, 5, 7})
+		require.Equal(t, []SchemaRegion{
+			{Start: 0, End: 4, Schema: 0},
+			{Start: 4, End: 7, Schema: histogram.CustomBucketsSchema, Custom: true},
+		}, regions)
+	})
+
+	t.Run("fully irregular set is one custom region", func(t *testing.T) {
+		regions := SchemaRegions([]float64{1, 3, 7})
+		require.Equal(t, []SchemaRegion{
+			{Start: 0, End: 3, Schema: histogram.CustomBucketsSchema, Custom: true},
+		}, regions)
+	})
+
+	t.Run("empty bounds yield no regions", func(t *testing.T) {
+		require.Empty(t, SchemaRegions(nil))
+	})
+}
+
+func TestParseCount(t *testing.T) {
+	for _, tc := range []struct {
+		s          string
+		expected   float64
+		expIsFloat bool
+	}{
+		{s: "25", expected: 25, expIsFloat: false},
+		{s: "2.5e2", expected: 250, expIsFloat: false},
+		{s: "0.5", expected: 0.5, expIsFloat: true},
+		{s: "+Inf", expected: math.Inf(1), expIsFloat: true},
+		{s: "9007199254740993", expected: 9007199254740992, expIsFloat: false},
+	} {
+		value, isFloat, err := ParseCount(tc.s)
+		require.NoError(t, err, "s=%q", tc.s)
+		require.Equal(t, tc.expected, value, "s=%q", tc.s)
+		require.Equal(t, tc.expIsFloat, isFloat, "s=%q", tc.s)
+	}
+
+	value, isFloat, err := ParseCount("NaN")
+	require.NoError(t, err)
+	require.True(t, math.IsNaN(value))
+	require.True(t, isFloat)
+
+	_, _, err = ParseCount("bogus")
+	require.Error(t, err)
+}
+
+func TestDetectSchema(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		upperBounds []float64
+		expSchema   int32
+		expIsCustom bool
+	}{
+		{
+			name:        "schema 0 powers of two",
+			upperBounds: []float64{1, 2, 4, 8},
+			expSchema:   0,
+			expIsCustom: false,
+		},
+		{
+			name:        "schema 0 with +Inf terminator",
+			upperBounds: []float64{0.5, 1, 2, math.Inf(1)},
+			expSchema:   0,
+			expIsCustom: false,
+		},
+		{
+			name:        "schema 2 quarter powers",
+			upperBounds: []float64{math.Exp2(0.25), math.Exp2(0.5), math.Exp2(0.75), 2},
+			expSchema:   2,
+			expIsCustom: false,
+		},
+		{
+			name:        "schema -2 sparse",
+			upperBounds: []float64{16, 4096},
+			expSchema:   -2,
+			expIsCustom: false,
+		},
+		{
+			name:        "irregular bounds",
+			upperBounds: []float64{1, 2, 3, math.Inf(1)},
+			expSchema:   histogram.CustomBucketsSchema,
+			expIsCustom: true,
+		},
+		{
+			name:        "non-positive bound",
+			upperBounds: []float64{0, 1, 2},
+			expSchema:   histogram.CustomBucketsSchema,
+			expIsCustom: true,
+		},
+		{
+			name:        "empty bounds",
+			upperBounds: []float64{},
+			expSchema:   histogram.CustomBucketsSchema,
+			expIsCustom: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			schema, isCustom := DetectSchema(tc.upperBounds)
+			require.Equal(t, tc.expSchema, schema)
+			require.Equal(t, tc.expIsCustom, isCustom)
+		})
+	}
+}
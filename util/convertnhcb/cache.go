@@ -0,0 +1,141 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"container/list"
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// Fingerprint returns a hash of the histogram's entire logical content: the
+// sorted bounds with their cumulative counts, the total count, the sum, the
+// float flag and the counter-reset hint. Two TempHistograms with the same
+// fingerprint convert to the same output, which is what makes it usable as a
+// conversion cache key.
+func (h TempHistogram) Fingerprint() uint64 {
+	var (
+		d   xxhash.Digest
+		buf [8]byte
+	)
+	d.Reset()
+	for _, le := range h.SortedBounds() {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(le))
+		_, _ = d.Write(buf[:])
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(h.BucketCounts[le]))
+		_, _ = d.Write(buf[:])
+	}
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(h.Count))
+	_, _ = d.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(h.Sum))
+	_, _ = d.Write(buf[:])
+	if h.HasFloat {
+		_, _ = d.Write([]byte{1})
+	} else {
+		_, _ = d.Write([]byte{0})
+	}
+	_, _ = d.Write([]byte{byte(h.CounterResetHint)})
+	return d.Sum64()
+}
+
+// ConversionCache memoizes conversion results for targets that expose
+// identical histograms scrape after scrape, as idle services do. It is a
+// size-bounded LRU keyed by TempHistogram.Fingerprint and safe for concurrent
+// use. Cached histograms are returned as copies, so callers may store and
+// mutate the result freely.
+//
+// The fingerprint covers only the input, not the conversion options, so one
+// cache must not be shared between call sites converting with different
+// Options.
+type ConversionCache struct {
+	mtx        sync.Mutex
+	maxEntries int
+	ll         *list.List
+	entries    map[uint64]*list.Element
+}
+
+type cacheEntry struct {
+	key uint64
+	h   *histogram.Histogram
+	fh  *histogram.FloatHistogram
+}
+
+// NewConversionCache returns a cache holding at most maxEntries conversion
+// results, evicting the least recently used one beyond that. maxEntries must
+// be positive.
+func NewConversionCache(maxEntries int) *ConversionCache {
+	if maxEntries <= 0 {
+		panic("convertnhcb: ConversionCache size must be positive")
+	}
+	return &ConversionCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    map[uint64]*list.Element{},
+	}
+}
+
+// Convert is like the package-level Convert, but consults the cache first and
+// stores the result on a miss. Conversions that return an error, including
+// best-effort soft errors, are not cached.
+func (c *ConversionCache) Convert(th TempHistogram, opts *Options) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	key := th.Fingerprint()
+	c.mtx.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(el)
+		e := el.Value.(*cacheEntry)
+		h, fh := copyResult(e.h, e.fh)
+		c.mtx.Unlock()
+		return h, fh, nil
+	}
+	c.mtx.Unlock()
+
+	h, fh, err := Convert(th, opts)
+	if err != nil {
+		return h, fh, err
+	}
+	// Store private copies so a caller mutating the returned histogram
+	// cannot poison the cache.
+	stored, storedFH := copyResult(h, fh)
+
+	c.mtx.Lock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.ll.PushFront(&cacheEntry{key: key, h: stored, fh: storedFH})
+		if c.ll.Len() > c.maxEntries {
+			oldest := c.ll.Back()
+			c.ll.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	c.mtx.Unlock()
+	return h, fh, nil
+}
+
+// Len returns the number of cached conversions.
+func (c *ConversionCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ll.Len()
+}
+
+func copyResult(h *histogram.Histogram, fh *histogram.FloatHistogram) (*histogram.Histogram, *histogram.FloatHistogram) {
+	if h != nil {
+		return h.Copy(), nil
+	}
+	return nil, fh.Copy()
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+func TestConversionCache(t *testing.T) {
+	cache := NewConversionCache(4)
+
+	direct, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+
+	h1, _, err := cache.Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, direct, h1)
+	require.Equal(t, 1, cache.Len())
+
+	// A hit returns an equal histogram, but not the cached instance itself.
+	h2, _, err := cache.Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, h1, h2)
+	require.NotSame(t, h1, h2)
+	require.Equal(t, 1, cache.Len())
+
+	// Mutating a returned histogram does not poison the cache.
+	h2.Count = 12345
+	h3, _, err := cache.Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, h1, h3)
+
+	// A distinct input misses and occupies its own slot.
+	other := TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, 2: 16, 3: 25, math.Inf(1): 25},
+		Count:        25,
+	}
+	other.SetSum(99.9)
+	hOther, _, err := cache.Convert(other, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, h1, hOther)
+	require.Equal(t, 2, cache.Len())
+}
+
+func TestConversionCacheCounterResetHint(t *testing.T) {
+	cache := NewConversionCache(4)
+
+	h, _, err := cache.Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, histogram.UnknownCounterReset, h.CounterResetHint)
+
+	// The same bucket contents stamped with a reset hint must not hit the
+	// earlier entry: the hint is part of the output, so it is part of the
+	// fingerprint.
+	reset := IntegerHistogramTest.Clone()
+	reset.CounterResetHint = histogram.CounterReset
+	require.NotEqual(t, IntegerHistogramTest.Fingerprint(), reset.Fingerprint())
+
+	h, _, err = cache.Convert(reset, nil)
+	require.NoError(t, err)
+	require.Equal(t, histogram.CounterReset, h.CounterResetHint)
+
+	// Both variants are cached independently and keep their hints on hits.
+	h, _, err = cache.Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, histogram.UnknownCounterReset, h.CounterResetHint)
+	require.Equal(t, 2, cache.Len())
+}
+
+func TestConversionCacheEviction(t *testing.T) {
+	cache := NewConversionCache(2)
+	for i := 0; i < 5; i++ {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: float64(i + 1), math.Inf(1): float64(i + 1)},
+			Count:        float64(i + 1),
+		}
+		th.SetSum(1)
+		_, _, err := cache.Convert(th, nil)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, cache.Len())
+}
+
+func TestConversionCacheConcurrent(t *testing.T) {
+	cache := NewConversionCache(8)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				th := TempHistogram{
+					BucketCounts: map[float64]float64{1: float64(j % 4), math.Inf(1): float64(j % 4)},
+					Count:        float64(j % 4),
+				}
+				th.SetSum(float64(i))
+				_, _, err := cache.Convert(th, nil)
+				require.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
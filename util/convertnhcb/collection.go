@@ -0,0 +1,176 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// collectionShards is the number of independently locked shards in a
+// Collection. It trades memory for reduced lock contention between
+// goroutines working on distinct series.
+const collectionShards = 64
+
+// Collection is a concurrency-safe map from a series key (typically a label
+// hash) to the in-progress TempHistogram for that series. It is meant for
+// scrape worker pools where several goroutines append bucket samples for
+// different series at the same time. Keys are sharded over independent
+// mutexes so that concurrent updates to distinct keys rarely contend, while
+// updates to the same key are serialized.
+type Collection struct {
+	// AbsoluteResets adapts the collection to push-based sources that send
+	// the full current totals every time: a cumulative count lower than the
+	// previous scrape's is a legitimate counter reset, not corruption.
+	// FinishScrape then skips the carry-forward merge for that scrape — the
+	// fresh totals stand on their own — and stamps histogram.CounterReset
+	// onto the returned TempHistogram for the converter to pick up. The
+	// result is still a counter semantically, unlike a gauge histogram. The
+	// field must be set before the collection is used.
+	AbsoluteResets bool
+
+	shards [collectionShards]collectionShard
+}
+
+type collectionShard struct {
+	mtx     sync.Mutex
+	entries map[uint64]*collectionEntry
+}
+
+type collectionEntry struct {
+	histogram TempHistogram
+	// lastUpdate is the timestamp passed to the most recent AddSampleAt for
+	// this series, used for TTL eviction.
+	lastUpdate int64
+	// prev holds the cumulative bucket counts as of the last FinishScrape,
+	// carried forward into the next scrape for bounds it does not report.
+	prev map[float64]float64
+}
+
+// NewCollection creates an empty Collection.
+func NewCollection() *Collection {
+	c := &Collection{}
+	for i := range c.shards {
+		c.shards[i].entries = map[uint64]*collectionEntry{}
+	}
+	return c
+}
+
+func (c *Collection) shard(key uint64) *collectionShard {
+	return &c.shards[key%collectionShards]
+}
+
+// AddSample records the cumulative bucket count value for the given upper
+// bound on the series identified by key, creating the TempHistogram on first
+// use. It is safe for concurrent use. It is equivalent to AddSampleAt with a
+// timestamp of 0, so collections mixing it with EvictOlderThan should use
+// AddSampleAt instead.
+func (c *Collection) AddSample(key uint64, upperBound, value float64) {
+	c.AddSampleAt(key, upperBound, value, 0)
+}
+
+// AddSampleAt is AddSample with the sample's timestamp (in milliseconds),
+// which refreshes the series' last-update time for EvictOlderThan.
+func (c *Collection) AddSampleAt(key uint64, upperBound, value float64, t int64) {
+	s := c.shard(key)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		e = &collectionEntry{histogram: NewTempHistogram()}
+		s.entries[key] = e
+	}
+	e.histogram.BucketCounts[upperBound] = value
+	if t > e.lastUpdate {
+		e.lastUpdate = t
+	}
+}
+
+// FinishScrape ends the current scrape for the series and returns its
+// histogram with carry-forward applied: any bound that a previous
+// FinishScrape saw but the current scrape did not report keeps its last
+// cumulative count instead of being treated as missing. This supports
+// delta-protocol sources that only send the buckets whose counts changed.
+// The merged counts become the baseline for the next scrape and the series'
+// accumulator is reset. In AbsoluteResets mode a scrape whose counts
+// decreased skips the merge and is marked as a counter reset instead. It
+// reports false if the key is unknown.
+func (c *Collection) FinishScrape(key uint64) (TempHistogram, bool) {
+	s := c.shard(key)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return TempHistogram{}, false
+	}
+	reset := false
+	if c.AbsoluteResets {
+		for le, count := range e.histogram.BucketCounts {
+			if prevCount, ok := e.prev[le]; ok && count < prevCount {
+				reset = true
+				break
+			}
+		}
+	}
+	if reset {
+		// After a reset the previous scrape's counts no longer apply;
+		// merging them in would fabricate non-monotonic buckets.
+		e.histogram.CounterResetHint = histogram.CounterReset
+	} else {
+		for le, count := range e.prev {
+			if _, reported := e.histogram.BucketCounts[le]; !reported {
+				e.histogram.BucketCounts[le] = count
+			}
+		}
+	}
+	th := e.histogram
+	e.prev = maps.Clone(th.BucketCounts)
+	e.histogram = NewTempHistogram()
+	return th, true
+}
+
+// EvictOlderThan drops every in-progress histogram whose last update is older
+// than t, returning how many were evicted. It bounds the memory held for
+// targets that stopped emitting mid-histogram.
+func (c *Collection) EvictOlderThan(t int64) int {
+	evicted := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mtx.Lock()
+		for key, e := range s.entries {
+			if e.lastUpdate < t {
+				delete(s.entries, key)
+				evicted++
+			}
+		}
+		s.mtx.Unlock()
+	}
+	return evicted
+}
+
+// ForEach calls f for every series currently held in the collection. The
+// shard holding the series is locked while f runs, so f must not call back
+// into the collection. Iteration order is unspecified.
+func (c *Collection) ForEach(f func(key uint64, th *TempHistogram)) {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mtx.Lock()
+		for key, e := range s.entries {
+			f(key, &e.histogram)
+		}
+		s.mtx.Unlock()
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+func TestCollectionConcurrentAddSample(t *testing.T) {
+	const (
+		goroutines = 16
+		keys       = 100
+	)
+	c := NewCollection()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for key := uint64(0); key < keys; key++ {
+				// Overlapping keys: every goroutine writes a distinct bound
+				// to every series.
+				c.AddSample(key, float64(g), float64(key))
+				// Disjoint keys: one series per goroutine.
+				c.AddSample(uint64(keys+g), 1, float64(g))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := map[uint64]*TempHistogram{}
+	c.ForEach(func(key uint64, th *TempHistogram) {
+		seen[key] = th
+	})
+	require.Len(t, seen, keys+goroutines)
+	for key := uint64(0); key < keys; key++ {
+		th := seen[key]
+		require.NotNil(t, th)
+		require.Len(t, th.BucketCounts, goroutines)
+		for g := 0; g < goroutines; g++ {
+			require.Equal(t, float64(key), th.BucketCounts[float64(g)])
+		}
+	}
+	for g := 0; g < goroutines; g++ {
+		th := seen[uint64(keys+g)]
+		require.NotNil(t, th)
+		require.Equal(t, float64(g), th.BucketCounts[1])
+	}
+}
+
+func TestCollectionFinishScrapeCarryForward(t *testing.T) {
+	c := NewCollection()
+	const key = 7
+
+	// First scrape reports every bucket.
+	c.AddSample(key, 1, 10)
+	c.AddSample(key, 2, 15)
+	c.AddSample(key, math.Inf(1), 15)
+	th, ok := c.FinishScrape(key)
+	require.True(t, ok)
+	require.Equal(t, map[float64]float64{1: 10, 2: 15, math.Inf(1): 15}, th.BucketCounts)
+
+	// The second scrape omits the unchanged le=1 bucket.
+	c.AddSample(key, 2, 18)
+	c.AddSample(key, math.Inf(1), 20)
+	th, ok = c.FinishScrape(key)
+	require.True(t, ok)
+	require.Equal(t, map[float64]float64{1: 10, 2: 18, math.Inf(1): 20}, th.BucketCounts)
+
+	h, _, err := Convert(th, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), h.Count)
+	require.Equal(t, 10.0, FractionInFirstBucket(h)*float64(h.Count))
+
+	_, ok = c.FinishScrape(99)
+	require.False(t, ok)
+}
+
+func TestCollectionEvictOlderThan(t *testing.T) {
+	c := NewCollection()
+	c.AddSampleAt(1, 0.5, 10, 1000)
+	c.AddSampleAt(2, 0.5, 10, 1000)
+	c.AddSampleAt(3, 0.5, 10, 3000)
+
+	// A later sample refreshes the series' timestamp.
+	c.AddSampleAt(1, 1, 12, 2000)
+
+	require.Equal(t, 1, c.EvictOlderThan(1500))
+
+	remaining := map[uint64]bool{}
+	c.ForEach(func(key uint64, th *TempHistogram) {
+		remaining[key] = true
+	})
+	require.Equal(t, map[uint64]bool{1: true, 3: true}, remaining)
+
+	// Evicting everything empties the collection.
+	require.Equal(t, 2, c.EvictOlderThan(5000))
+	count := 0
+	c.ForEach(func(uint64, *TempHistogram) { count++ })
+	require.Equal(t, 0, count)
+}
+
+func TestCollectionAbsoluteResets(t *testing.T) {
+	c := NewCollection()
+	c.AbsoluteResets = true
+	key := uint64(7)
+
+	// First scrape: ordinary totals.
+	c.AddSample(key, 1, 10)
+	c.AddSample(key, 2, 15)
+	c.AddSample(key, math.Inf(1), 20)
+	th, ok := c.FinishScrape(key)
+	require.True(t, ok)
+	require.Equal(t, histogram.UnknownCounterReset, th.CounterResetHint)
+
+	// Second scrape: the totals dropped, a legitimate reset. The le=2 bucket
+	// is not reported; carrying its old count forward would fabricate a
+	// non-monotonic sequence, so the merge must be skipped.
+	c.AddSample(key, 1, 2)
+	c.AddSample(key, math.Inf(1), 5)
+	th, ok = c.FinishScrape(key)
+	require.True(t, ok)
+	require.Equal(t, histogram.CounterReset, th.CounterResetHint)
+	require.NotContains(t, th.BucketCounts, 2.0)
+
+	h, _, err := Convert(th, nil)
+	require.NoError(t, err)
+	require.Equal(t, histogram.CounterReset, h.CounterResetHint)
+	require.NoError(t, h.ValidateCustomBuckets())
+
+	// Third scrape: counts increased again, carry-forward resumes.
+	c.AddSample(key, 1, 4)
+	c.AddSample(key, math.Inf(1), 9)
+	th, ok = c.FinishScrape(key)
+	require.True(t, ok)
+	require.Equal(t, histogram.UnknownCounterReset, th.CounterResetHint)
+}
@@ -0,0 +1,691 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// DefaultSourceLabel is the label name used by Options.AnnotateSource unless
+// overridden via Options.SourceLabel.
+const DefaultSourceLabel = "__nhcb_source__"
+
+// Profile is a named bundle of input validation rules for Convert.
+type Profile int
+
+const (
+	// ProfileLenient trusts the input the way classic Prometheus scraping
+	// does, applying only the fixups NewHistogram always applied.
+	ProfileLenient Profile = iota
+	// ProfileOpenMetrics enforces the rules OpenMetrics imposes on classic
+	// histograms: the +Inf bucket must be present, cumulative counts must be
+	// non-decreasing, and a _count must accompany non-empty buckets.
+	ProfileOpenMetrics
+)
+
+// Options configures the optional behavior of Convert. The zero value (and a
+// nil *Options) applies no limits and no fixups beyond what NewHistogram
+// does.
+type Options struct {
+	// MaxBuckets, when positive, limits the number of buckets in the
+	// converted histogram. An input exceeding the limit is rejected with an
+	// error unless MergeMaxBuckets is set.
+	MaxBuckets int
+	// MergeMaxBuckets merges adjacent buckets (dropping intermediate
+	// boundaries and combining their counts into the next kept bucket)
+	// instead of returning an error when MaxBuckets is exceeded. The last
+	// bound, typically +Inf, is always kept.
+	MergeMaxBuckets bool
+	// MinBuckets, when positive, rejects histograms with fewer finite
+	// buckets than the floor. One- or two-bucket histograms are nearly
+	// useless for quantile estimation, and the error lets ingestion route
+	// such low-quality sources differently. The +Inf overflow bucket does
+	// not count toward the floor.
+	MinBuckets int
+	// Profile selects a named validation profile applied before conversion.
+	// The zero value is ProfileLenient.
+	Profile Profile
+	// RequireInfBucket rejects histograms that expose no +Inf bucket at all
+	// with ErrNoInfBucket. The default synthesizes nothing and converts the
+	// finite buckets as-is.
+	RequireInfBucket bool
+	// BestEffort makes Convert return the best-effort histogram alongside a
+	// descriptive error instead of nothing when the input is recoverably
+	// broken: non-monotonic cumulative counts are clamped up to the running
+	// maximum and the conversion continues, so the caller can choose between
+	// ingest-with-caveat and drop. Hard errors — no buckets at all,
+	// non-finite counts — still return a nil histogram.
+	BestEffort bool
+	// StrictCount rejects histograms whose +Inf bucket reports fewer
+	// observations than the total count, which is physically impossible and
+	// indicates a scrape race on the target. The default trusts the input
+	// and converts it anyway.
+	StrictCount bool
+	// Scale, when non-zero and not 1, multiplies all bucket counts, the
+	// total count and the sum by the given factor, e.g. to normalize
+	// per-minute pre-aggregated data to per-second. Scaling can produce
+	// fractional counts, so it always forces the float output path.
+	Scale float64
+	// Compact collapses runs of empty buckets in the result into span gaps,
+	// like NewHistogram does, which stores long zero runs far more
+	// efficiently. The default keeps Convert's dense single span so bucket
+	// positions map 1:1 to the source bounds. The logical contents are the
+	// same either way.
+	Compact bool
+	// PreserveEmptyBuckets pins the result to the dense single-span layout,
+	// keeping every source bound as a visible bucket position even when its
+	// bucket is empty. It takes precedence over Compact, for consumers that
+	// need full fidelity to the source bound set rather than the smallest
+	// encoding.
+	PreserveEmptyBuckets bool
+	// SplitZeroBucket splits a leading le=0 bucket out of the custom
+	// buckets: the bucket and its observations are removed from the
+	// histogram (Count shrinks accordingly) and the removed count is
+	// reported out-of-band via Stats.ZeroCount. The custom buckets spec
+	// requires the native zero bucket to stay unused, so the count cannot
+	// live on the histogram itself without failing validation. The default
+	// keeps the le=0 bound as an ordinary bucket.
+	SplitZeroBucket bool
+	// BucketLimit, when positive, caps the number of buckets like a scrape
+	// bucket limit. Exceeding it is an error unless ReduceResolution is set.
+	BucketLimit int
+	// ReduceResolution makes Convert progressively halve the custom bounds —
+	// dropping every other one, keeping the topmost — until the histogram
+	// fits under BucketLimit, summing counts into the merged buckets. This
+	// mirrors native-histogram resolution reduction for custom buckets.
+	ReduceResolution bool
+	// MaxAbsSum, when positive, rejects histograms whose sum's absolute value
+	// exceeds it, catching exporter bugs that emit absurd sums which overflow
+	// to +Inf under downstream aggregation. The default accepts any sum.
+	MaxAbsSum float64
+	// InfThreshold, when positive, treats finite bounds at or above it as
+	// +Inf, folding their buckets into the overflow bucket. Broken exporters
+	// have emitted both le="+Inf" and le="1e300" for the overflow bucket;
+	// without folding, the near-infinite finite bound pollutes CustomValues.
+	// Conflicting cumulative counts merge by maximum. The default of zero
+	// keeps all finite bounds distinct.
+	InfThreshold float64
+	// TrimEmptyInf drops the trailing +Inf overflow bucket from the dense
+	// layout when its delta is zero, i.e. when the top finite bucket already
+	// holds all observations. Compact subsumes this (it drops every empty
+	// edge bucket), so TrimEmptyInf is for callers who want the dense layout
+	// otherwise kept. The logical contents are unchanged.
+	TrimEmptyInf bool
+	// PreRated marks inputs whose values arrived already divided by the
+	// scrape interval, as Graphite-style bridges deliver them. Conversion
+	// multiplies all counts and the sum by RateIntervalSeconds to reconstruct
+	// the original counts. Unlike Scale this is interval-aware, and since the
+	// reconstruction is inexact it always takes the float path.
+	PreRated bool
+	// RateIntervalSeconds is the interval the pre-rated values were divided
+	// by. It must be positive when PreRated is set.
+	RateIntervalSeconds float64
+	// Interner, when non-nil, replaces the result's CustomValues with a
+	// shared slice from the interner, so histograms with identical bucket
+	// layouts point at the same backing array.
+	Interner *BoundsInterner
+	// Warnings, when non-nil, collects a note for every non-fatal fixup
+	// applied during conversion, as in NewHistogramWithWarnings.
+	Warnings *[]ConvertWarning
+	// Stats, when non-nil, collects machine-readable diagnostics about the
+	// conversion. The fields are appended to, so a caller reusing one
+	// ConvertStats across conversions must reset it in between.
+	Stats *ConvertStats
+	// CollectCumulative additionally records the cumulative bucket counts in
+	// Stats.Cumulative, for analytics that want them alongside the
+	// delta-encoded output without recomputing. It has no effect without
+	// Stats.
+	CollectCumulative bool
+	// AdviseSchema emits an advisory warning when the source bounds do not
+	// lie on any standard exponential schema's bucket boundaries, meaning the
+	// series ends up stored as the less efficient CustomBucketsSchema. It is
+	// purely informational, meant for migration guidance, and needs Warnings
+	// set to be visible.
+	AdviseSchema bool
+	// TrimLeadingMissing drops bounds below the first reported bucket from
+	// the bound set instead of zero-filling them, for pipelines that prefer
+	// to start the series at the first bucket the target actually exposed.
+	// The default zero-fills leading missing buckets like any other.
+	TrimLeadingMissing bool
+	// CounterResetHint is stamped onto the converted histogram when it is not
+	// UnknownCounterReset, for ingestion layers that already know whether the
+	// classic series underwent a counter reset (e.g. on the first sample of a
+	// new series). The default leaves the hint at UnknownCounterReset.
+	CounterResetHint histogram.CounterResetHint
+	// SortInput makes NewHistogramWithOptions accept an unsorted upperBounds
+	// slice: the bounds are sorted into a copy and the base histograms are
+	// rebuilt to match, so callers assembling bounds from a map get correct
+	// output instead of silent corruption. The default assumes pre-sorted
+	// input, avoiding the check for callers who already sort.
+	SortInput bool
+	// StrictLe makes the StreamBuilder fail fast on a _bucket series whose le
+	// label does not parse, instead of the lenient default of skipping the
+	// bucket with a warning. Skipped buckets are indistinguishable from
+	// missing ones, so strict callers prefer the immediate error over a
+	// misleading histogram.
+	StrictLe bool
+	// Suffixes, when non-nil, overrides the metric name suffixes by which
+	// histogram component series are recognized. Nil means the standard
+	// Prometheus suffixes of DefaultSuffixSet.
+	Suffixes *SuffixSet
+	// BucketLabel overrides the label name carrying the bucket's upper bound
+	// on _bucket series, for exporters that use e.g. "upper_bound" instead of
+	// the Prometheus convention. The configured name is also the one stripped
+	// from the base label set. Empty means the standard "le".
+	BucketLabel string
+	// AnnotateSource makes the StreamBuilder record the original metric name
+	// of the first component series seen, before suffix stripping, in a label
+	// on the emitted histogram. The label is an ordinary label that relabeling
+	// can remove again.
+	AnnotateSource bool
+	// SourceLabel overrides the label name used by AnnotateSource. Empty
+	// means DefaultSourceLabel.
+	SourceLabel string
+	// DeltaEncoder, when non-nil, replaces the integer path's bucket encoding
+	// with a caller-supplied one; see the DeltaEncoder type. Nil uses
+	// DefaultDeltaEncoder, which reproduces NewHistogram's output exactly.
+	DeltaEncoder DeltaEncoder
+	// TraceHook, when non-nil, is invoked with a phase name as the conversion
+	// enters each phase — "bounds" (bound derivation, validation and
+	// reduction), "deltas" (bucket count computation and encoding),
+	// "finalize" (post-conversion fixups) — and finally with "done", so a
+	// high-throughput caller can attribute latency to phases by timestamping
+	// the calls. Nil disables tracing.
+	TraceHook func(phase string)
+	// Metrics, when non-nil, is incremented as the converter works:
+	// conversions, errors by reason, and zero-filled buckets. See NewMetrics.
+	Metrics *Metrics
+	// TransformBaseLabels, when non-nil, rewrites the base label set of the
+	// converted histogram, e.g. to rename the metric or attach a marker
+	// label. It runs exactly once per histogram, after the component suffix
+	// and bucket label have already been stripped.
+	TransformBaseLabels func(labels.Labels) labels.Labels
+}
+
+// ConvertStats holds machine-readable diagnostics about a single conversion,
+// collected when requested via Options.Stats.
+type ConvertStats struct {
+	// MissingBounds lists the upper bounds that had no entry at all in
+	// BucketCounts, as opposed to buckets that were reported with a zero
+	// increment.
+	MissingBounds []float64
+	// Cumulative holds the cumulative bucket counts the conversion computed,
+	// aligned with the sorted upper bounds including +Inf. It is only
+	// populated when Options.CollectCumulative is set and stays nil
+	// otherwise.
+	Cumulative []float64
+	// LeStrings carries the original textual le values recorded on the input
+	// via TempHistogram.SetBucketString, keyed by parsed bound, so
+	// NHCB-to-classic reconstruction can emit the exact source strings; see
+	// FormatBoundsWithStrings. It stays nil when the input recorded none.
+	LeStrings map[float64]string
+	// ZeroCount reports the observations removed from a leading le=0 bucket
+	// by Options.SplitZeroBucket. It is only populated when that option is
+	// set and stays 0 otherwise.
+	ZeroCount float64
+	// FloatAutoDetected reports that the input did not declare HasFloat but
+	// carried a fractional bucket or total count, so the conversion promoted
+	// it to the float path. It flags exporters that emit fractions on what
+	// should be integer counters.
+	FloatAutoDetected bool
+	// Lossless reports whether the conversion preserved the input exactly: no
+	// bucket was zero-filled, no buckets were merged away, and the reported
+	// count was consistent with the buckets. Migration tooling uses it to
+	// decide whether the classic series still need to be retained. Convert
+	// starts it out true and any lossy fixup flips it to false.
+	Lossless bool
+}
+
+// warn appends a formatted warning to the configured collector, if any. It
+// is safe to call on a nil Options.
+func (o *Options) warn(format string, args ...interface{}) {
+	if o == nil {
+		return
+	}
+	addWarning(o.Warnings, format, args...)
+}
+
+// forceFloat reports whether the configured options can only be represented
+// by the float output path.
+func (o *Options) forceFloat() bool {
+	return o != nil && (o.PreRated || (o.Scale != 0 && o.Scale != 1))
+}
+
+// counterResetHint returns the hint to stamp onto the output, keeping the
+// given current value unless the options override it. It is safe to call on a
+// nil Options.
+func (o *Options) counterResetHint(current histogram.CounterResetHint) histogram.CounterResetHint {
+	if o == nil || o.CounterResetHint == histogram.UnknownCounterReset {
+		return current
+	}
+	return o.CounterResetHint
+}
+
+// combineResetHints resolves the hint on the output histogram against the one
+// carried by the input TempHistogram: the input's hint wins when it is set.
+// It exists outside the finish helpers because their TempHistogram parameter
+// shadows the histogram package name.
+func combineResetHints(base, fromInput histogram.CounterResetHint) histogram.CounterResetHint {
+	if fromInput == histogram.UnknownCounterReset {
+		return base
+	}
+	return fromInput
+}
+
+// bucketLabel returns the configured bucket-bound label name, defaulting to
+// the standard "le". It is safe to call on a nil Options.
+func (o *Options) bucketLabel() string {
+	if o == nil || o.BucketLabel == "" {
+		return labels.BucketLabel
+	}
+	return o.BucketLabel
+}
+
+// sortInput reports whether unsorted bounds should be tolerated. It is safe
+// to call on a nil Options.
+func (o *Options) sortInput() bool {
+	return o != nil && o.SortInput
+}
+
+// missingBound records that an upper bound had no reported bucket.
+func (o *Options) missingBound(le float64) {
+	o.warn("bucket with le=%g not reported, assuming a cumulative count of 0", le)
+	o.markLossy()
+	if o != nil && o.Stats != nil {
+		o.Stats.MissingBounds = append(o.Stats.MissingBounds, le)
+	}
+	if o != nil && o.Metrics != nil {
+		o.Metrics.BucketsZeroFilled.Inc()
+	}
+}
+
+// appendCumulative records one cumulative bucket count when collection is
+// requested.
+func (o *Options) appendCumulative(v float64) {
+	if o != nil && o.CollectCumulative && o.Stats != nil {
+		o.Stats.Cumulative = append(o.Stats.Cumulative, v)
+	}
+}
+
+// floatAutoDetected records that an input not declaring HasFloat was promoted
+// to the float path.
+func (o *Options) floatAutoDetected() {
+	if o != nil && o.Stats != nil {
+		o.Stats.FloatAutoDetected = true
+	}
+}
+
+// trace reports entry into a conversion phase to the configured hook, if
+// any. It is safe to call on a nil Options.
+func (o *Options) trace(phase string) {
+	if o == nil || o.TraceHook == nil {
+		return
+	}
+	o.TraceHook(phase)
+}
+
+// markLossy records that the conversion applied a fixup that loses
+// information from the input.
+func (o *Options) markLossy() {
+	if o != nil && o.Stats != nil {
+		o.Stats.Lossless = false
+	}
+}
+
+// Convert builds a native histogram with custom buckets from the classic
+// histogram samples collected in th, deriving the upper bounds from
+// th.BucketCounts. It covers the common case where the caller does not
+// manage bound sets and base histograms itself; those callers should keep
+// using ProcessUpperBoundsAndCreateBaseHistogram and NewHistogram directly.
+// Unlike NewHistogram, the result keeps one dense span covering every bound
+// so that bucket positions map 1:1 to the source bounds, unless Compact is
+// requested in opts.
+// Exactly one of the returned histograms is non-nil, depending on whether
+// the input required the float path. Under Options.BestEffort a histogram
+// can be returned together with a non-nil error describing the fixup.
+func Convert(th TempHistogram, opts *Options) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	h, fh, err := convert(th, opts)
+	if opts != nil && opts.Metrics != nil {
+		opts.Metrics.observeConversion(err)
+	}
+	return h, fh, err
+}
+
+func convert(th TempHistogram, opts *Options) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Stats != nil {
+		opts.Stats.Lossless = true
+		if th.BucketStrings != nil {
+			opts.Stats.LeStrings = maps.Clone(th.BucketStrings)
+		}
+	}
+	if len(th.BucketCounts) == 0 {
+		return nil, nil, fmt.Errorf("no buckets to convert")
+	}
+	if opts.Scale != 0 && opts.Scale != 1 {
+		th = th.scaled(opts.Scale)
+	}
+	if opts.PreRated {
+		if opts.RateIntervalSeconds <= 0 {
+			return nil, nil, fmt.Errorf("PreRated conversion requires a positive RateIntervalSeconds, got %g", opts.RateIntervalSeconds)
+		}
+		th = th.scaled(opts.RateIntervalSeconds)
+	}
+	if opts.InfThreshold > 0 {
+		var folded map[float64]float64
+		for le, count := range th.BucketCounts {
+			if math.IsInf(le, 1) || math.IsNaN(le) || le < opts.InfThreshold {
+				continue
+			}
+			if folded == nil {
+				// Fold into a copy; the caller's map is left untouched.
+				folded = maps.Clone(th.BucketCounts)
+			}
+			delete(folded, le)
+			if existing, ok := folded[math.Inf(1)]; !ok || count > existing {
+				folded[math.Inf(1)] = count
+			}
+			opts.warn("folded near-infinite bound le=%g into the +Inf bucket", le)
+		}
+		if folded != nil {
+			opts.markLossy()
+			th.BucketCounts = folded
+		}
+	}
+	// A zero sum that was never explicitly set means the target exposed no
+	// _sum series; NaN is the conventional way to say so in the output. A
+	// non-zero sum is kept even without SetSum for callers that still assign
+	// the field directly.
+	if !th.SumProvided && th.Sum == 0 {
+		th.Sum = math.NaN()
+	}
+	if opts.MaxAbsSum > 0 && math.Abs(th.Sum) > opts.MaxAbsSum {
+		return nil, nil, fmt.Errorf("sum %g exceeds the configured maximum magnitude %g", th.Sum, opts.MaxAbsSum)
+	}
+	if math.IsNaN(th.Count) || math.IsInf(th.Count, 0) {
+		return nil, nil, fmt.Errorf("total count %g: %w", th.Count, ErrNonFiniteCount)
+	}
+	opts.trace("bounds")
+	upperBounds := make([]float64, 0, len(th.BucketCounts))
+	for le, count := range th.BucketCounts {
+		if math.IsNaN(count) || math.IsInf(count, 0) {
+			return nil, nil, fmt.Errorf("bucket le=%g count %g: %w", le, count, ErrNonFiniteCount)
+		}
+		upperBounds = append(upperBounds, le)
+	}
+	sort.Float64s(upperBounds)
+
+	if opts.AdviseSchema {
+		if _, isCustom := DetectSchema(upperBounds); isCustom {
+			opts.warn("bounds do not match any standard exponential schema, the series will be stored with custom buckets")
+		}
+	}
+
+	if opts.Profile == ProfileOpenMetrics {
+		infCount, ok := th.BucketCounts[math.Inf(1)]
+		if !ok {
+			return nil, nil, fmt.Errorf("OpenMetrics profile: %w", ErrNoInfBucket)
+		}
+		if err := VerifyCumulative(upperBounds, th.BucketCounts); err != nil {
+			return nil, nil, fmt.Errorf("OpenMetrics profile: %w", err)
+		}
+		if th.Count == 0 && infCount != 0 {
+			return nil, nil, fmt.Errorf("OpenMetrics profile: histogram has observations but no _count series")
+		}
+	}
+	if opts.RequireInfBucket {
+		if _, ok := th.BucketCounts[math.Inf(1)]; !ok {
+			return nil, nil, ErrNoInfBucket
+		}
+	}
+	if opts.StrictCount {
+		if infCount, ok := th.BucketCounts[math.Inf(1)]; ok && infCount < th.Count {
+			return nil, nil, fmt.Errorf("+Inf bucket count %g is below the total count %g: %w", infCount, th.Count, ErrNonMonotonic)
+		}
+	}
+
+	if opts.MinBuckets > 0 {
+		finite := len(upperBounds)
+		if math.IsInf(upperBounds[finite-1], 1) {
+			finite--
+		}
+		if finite < opts.MinBuckets {
+			return nil, nil, fmt.Errorf("histogram has %d finite buckets, below the required minimum of %d", finite, opts.MinBuckets)
+		}
+	}
+
+	var softErr error
+	if opts.BestEffort {
+		if err := VerifyCumulative(upperBounds, th.BucketCounts); err != nil {
+			// Clamp every cumulative count up to the running maximum in a
+			// copy; the caller's map is left untouched.
+			clamped := make(map[float64]float64, len(th.BucketCounts))
+			running := math.Inf(-1)
+			for _, le := range upperBounds {
+				count := th.BucketCounts[le]
+				if count < running {
+					count = running
+				}
+				running = count
+				clamped[le] = count
+			}
+			th.BucketCounts = clamped
+			opts.warn("clamped non-monotonic cumulative counts: %s", err)
+			opts.markLossy()
+			softErr = fmt.Errorf("best-effort conversion applied: %w", err)
+		}
+	}
+
+	if opts.MaxBuckets > 0 && len(upperBounds) > opts.MaxBuckets {
+		if !opts.MergeMaxBuckets {
+			return nil, nil, fmt.Errorf("histogram has %d buckets, exceeding the limit of %d", len(upperBounds), opts.MaxBuckets)
+		}
+		merged := mergeBoundsToLimit(upperBounds, opts.MaxBuckets)
+		opts.warn("merged %d buckets into %d to fit the bucket limit", len(upperBounds), len(merged))
+		opts.markLossy()
+		upperBounds = merged
+	}
+
+	if opts.BucketLimit > 0 && len(upperBounds) > opts.BucketLimit {
+		if !opts.ReduceResolution {
+			return nil, nil, fmt.Errorf("histogram has %d buckets, exceeding the bucket limit of %d", len(upperBounds), opts.BucketLimit)
+		}
+		orig := len(upperBounds)
+		for len(upperBounds) > opts.BucketLimit {
+			upperBounds = halveBounds(upperBounds)
+		}
+		opts.warn("reduced resolution from %d to %d buckets to fit the bucket limit", orig, len(upperBounds))
+		opts.markLossy()
+	}
+
+	_, hBase := ProcessUpperBoundsAndCreateBaseHistogram(upperBounds, false)
+	fhBase := hBase.ToFloat(nil)
+	opts.trace("deltas")
+	compact := opts.Compact && !opts.PreserveEmptyBuckets
+	// The zero-bucket split relies on the dense layout, where the le=0
+	// bucket sits at offset 0. Compaction would drop that bucket when it is
+	// empty, making a later split steal the next bucket's count, so the
+	// split runs first and compaction follows.
+	h, fh := newHistogram(th, upperBounds, hBase, fhBase, opts, compact && !opts.SplitZeroBucket)
+	opts.trace("finalize")
+	if opts.SplitZeroBucket {
+		splitZeroBucket(h, fh, opts)
+		if compact {
+			if h != nil {
+				h = h.Compact(0)
+			} else {
+				fh = fh.Compact(0)
+			}
+		}
+	}
+	if opts.TrimEmptyInf && !opts.Compact && !opts.PreserveEmptyBuckets {
+		trimEmptyInf(h, fh)
+	}
+	if opts.Interner != nil {
+		// The bases are copied during conversion, so the interned slice has
+		// to be swapped in on the result.
+		if h != nil {
+			h.CustomValues = opts.Interner.Intern(h.CustomValues)
+		}
+		if fh != nil {
+			fh.CustomValues = opts.Interner.Intern(fh.CustomValues)
+		}
+	}
+	opts.trace("done")
+	return h, fh, softErr
+}
+
+// DryRun runs the validation and fixup detection of a conversion against the
+// given bounds without building a histogram, for validation tooling that only
+// wants to know what a conversion would do. The returned stats report the
+// same missing buckets, lossiness and float auto-detection that
+// NewHistogramWithOptions would record for the same input.
+func DryRun(th TempHistogram, bounds []float64) (ConvertStats, error) {
+	stats := ConvertStats{Lossless: true}
+	opts := &Options{Stats: &stats}
+	if len(bounds) == 0 {
+		return stats, fmt.Errorf("no buckets to convert")
+	}
+	if math.IsNaN(th.Count) || math.IsInf(th.Count, 0) {
+		return stats, fmt.Errorf("total count %g: %w", th.Count, ErrNonFiniteCount)
+	}
+	sorted := make([]float64, len(bounds))
+	copy(sorted, bounds)
+	sort.Float64s(sorted)
+
+	isFloat := false
+	var total float64
+	for _, le := range sorted {
+		count, ok := th.BucketCounts[le]
+		if !ok {
+			count = 0
+			opts.missingBound(le)
+		}
+		if math.IsNaN(count) || math.IsInf(count, 0) {
+			return stats, fmt.Errorf("bucket le=%g count %g: %w", le, count, ErrNonFiniteCount)
+		}
+		if count != math.Round(count) {
+			isFloat = true
+		}
+		total = count
+	}
+	if th.Count != math.Round(th.Count) {
+		isFloat = true
+	}
+	if isFloat && !th.HasFloat {
+		opts.floatAutoDetected()
+	}
+	if th.Count != 0 && th.Count != total {
+		opts.markLossy()
+	}
+	return stats, nil
+}
+
+// trimEmptyInf drops the last bucket of the dense single-span layout when it
+// is the +Inf overflow and holds no observations. The overflow bucket is the
+// one past the finite bounds, so it is only present when the bucket count
+// exceeds len(CustomValues).
+func trimEmptyInf(h *histogram.Histogram, fh *histogram.FloatHistogram) {
+	if h != nil && len(h.PositiveBuckets) == len(h.CustomValues)+1 {
+		var abs int64
+		for _, d := range h.PositiveBuckets {
+			abs += d
+		}
+		if abs == 0 {
+			h.PositiveBuckets = h.PositiveBuckets[:len(h.PositiveBuckets)-1]
+			h.PositiveSpans[len(h.PositiveSpans)-1].Length--
+		}
+	}
+	if fh != nil && len(fh.PositiveBuckets) == len(fh.CustomValues)+1 {
+		if fh.PositiveBuckets[len(fh.PositiveBuckets)-1] == 0 {
+			fh.PositiveBuckets = fh.PositiveBuckets[:len(fh.PositiveBuckets)-1]
+			fh.PositiveSpans[len(fh.PositiveSpans)-1].Length--
+		}
+	}
+}
+
+// splitZeroBucket removes a leading le=0 bucket from the custom buckets,
+// reporting its count via Stats.ZeroCount and shrinking Count to match, so
+// the result still satisfies the custom-buckets invariant of an unused zero
+// bucket. It relies on Convert's dense single-span layout, where the le=0
+// bucket sits at offset 0, and must therefore run before any compaction.
+func splitZeroBucket(h *histogram.Histogram, fh *histogram.FloatHistogram, opts *Options) {
+	if h != nil && len(h.CustomValues) > 0 && h.CustomValues[0] == 0 {
+		zc := h.PositiveBuckets[0]
+		if opts != nil && opts.Stats != nil {
+			opts.Stats.ZeroCount = float64(zc)
+		}
+		if zc != 0 {
+			opts.markLossy()
+		}
+		h.Count -= uint64(zc)
+		h.CustomValues = h.CustomValues[1:]
+		// Fold the removed bucket's delta into its successor so the absolute
+		// counts of the remaining buckets are unchanged.
+		if len(h.PositiveBuckets) > 1 {
+			h.PositiveBuckets[1] += h.PositiveBuckets[0]
+		}
+		h.PositiveBuckets = h.PositiveBuckets[1:]
+		h.PositiveSpans[0].Length--
+	}
+	if fh != nil && len(fh.CustomValues) > 0 && fh.CustomValues[0] == 0 {
+		zc := fh.PositiveBuckets[0]
+		if opts != nil && opts.Stats != nil {
+			opts.Stats.ZeroCount = zc
+		}
+		if zc != 0 {
+			opts.markLossy()
+		}
+		fh.Count -= zc
+		fh.CustomValues = fh.CustomValues[1:]
+		fh.PositiveBuckets = fh.PositiveBuckets[1:]
+		fh.PositiveSpans[0].Length--
+	}
+}
+
+// mergeBoundsToLimit reduces bounds to at most limit entries by dropping
+// intermediate boundaries at a regular stride. Since bucket counts are
+// cumulative, dropping a boundary folds its bucket's counts into the next
+// kept bucket. The walk is backwards so the last bound is always kept.
+func mergeBoundsToLimit(bounds []float64, limit int) []float64 {
+	stride := (len(bounds) + limit - 1) / limit
+	merged := make([]float64, 0, limit)
+	for i := len(bounds) - 1; i >= 0; i -= stride {
+		merged = append(merged, bounds[i])
+	}
+	slices.Reverse(merged)
+	return merged
+}
+
+// halveBounds is one resolution-reduction step: it keeps every other bound,
+// walking backwards so the topmost bound always survives. As in
+// mergeBoundsToLimit, the dropped bounds' counts fold into the next kept
+// bucket.
+func halveBounds(bounds []float64) []float64 {
+	halved := make([]float64, 0, (len(bounds)+1)/2)
+	for i := len(bounds) - 1; i >= 0; i -= 2 {
+		halved = append(halved, bounds[i])
+	}
+	slices.Reverse(halved)
+	return halved
+}
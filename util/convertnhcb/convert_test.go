@@ -0,0 +1,819 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+func TestConvert(t *testing.T) {
+	h, fh, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Nil(t, fh)
+	require.NotNil(t, h)
+	require.NoError(t, h.Validate())
+	require.Equal(t, uint64(25), h.Count)
+	require.Equal(t, 99.9, h.Sum)
+	require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+
+	// Logically the same histogram as the low-level path, modulo span
+	// compaction.
+	upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+	hPlain, _ := NewHistogram(IntegerHistogramTest, upperBounds, hBase, fhBase)
+	require.Equal(t, hPlain, h.Copy().Compact(0))
+}
+
+func TestConvertStatsMissingBounds(t *testing.T) {
+	upperBounds, hBase, fhBase := processTestBounds(t, MissingBucketTestIntBounds)
+	var stats ConvertStats
+	h, fh := NewHistogramWithOptions(MissingBucketTestInt, upperBounds, hBase, fhBase, &Options{Stats: &stats})
+	require.NotNil(t, h)
+	require.Nil(t, fh)
+	require.Equal(t, []float64{2}, stats.MissingBounds)
+
+	stats = ConvertStats{}
+	h, fh = NewHistogramWithOptions(IntegerHistogramTest, upperBounds, hBase, fhBase, &Options{Stats: &stats})
+	require.NotNil(t, h)
+	require.Nil(t, fh)
+	require.Empty(t, stats.MissingBounds)
+}
+
+func TestConvertSumProvided(t *testing.T) {
+	t.Run("absent sum becomes NaN", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 25},
+			Count:        25,
+		}
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.True(t, math.IsNaN(h.Sum))
+	})
+
+	t.Run("explicit zero sum is preserved", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 25},
+			Count:        25,
+		}
+		th.SetSum(0)
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, 0.0, h.Sum)
+	})
+
+	t.Run("directly assigned non-zero sum still works", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		require.Equal(t, 99.9, h.Sum)
+	})
+}
+
+func TestConvertLossless(t *testing.T) {
+	t.Run("clean integer histogram is lossless", func(t *testing.T) {
+		var stats ConvertStats
+		_, _, err := Convert(IntegerHistogramTest, &Options{Stats: &stats})
+		require.NoError(t, err)
+		require.True(t, stats.Lossless)
+	})
+
+	t.Run("zero-filled bucket is lossy", func(t *testing.T) {
+		var stats ConvertStats
+		upperBounds, hBase, fhBase := processTestBounds(t, MissingBucketTestIntBounds)
+		NewHistogramWithOptions(MissingBucketTestInt, upperBounds, hBase, fhBase, &Options{Stats: &stats})
+		require.False(t, stats.Lossless)
+	})
+
+	t.Run("count override is lossy", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 20},
+			Count:        25,
+		}
+		var stats ConvertStats
+		_, _, err := Convert(th, &Options{Stats: &stats})
+		require.NoError(t, err)
+		require.False(t, stats.Lossless)
+	})
+
+	t.Run("bucket merge is lossy", func(t *testing.T) {
+		th := NewTempHistogram()
+		for i := 1; i <= 10; i++ {
+			th.BucketCounts[float64(i)] = float64(i)
+		}
+		th.Count = 10
+		var stats ConvertStats
+		_, _, err := Convert(th, &Options{MaxBuckets: 5, MergeMaxBuckets: true, Stats: &stats})
+		require.NoError(t, err)
+		require.False(t, stats.Lossless)
+	})
+}
+
+func TestConvertCollectCumulative(t *testing.T) {
+	upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+
+	t.Run("requested", func(t *testing.T) {
+		var stats ConvertStats
+		NewHistogramWithOptions(IntegerHistogramTest, upperBounds, hBase, fhBase, &Options{Stats: &stats, CollectCumulative: true})
+		require.Equal(t, []float64{10, 15, 25, 25}, stats.Cumulative)
+	})
+
+	t.Run("not requested stays nil", func(t *testing.T) {
+		var stats ConvertStats
+		NewHistogramWithOptions(IntegerHistogramTest, upperBounds, hBase, fhBase, &Options{Stats: &stats})
+		require.Nil(t, stats.Cumulative)
+	})
+}
+
+func TestConvertScale(t *testing.T) {
+	h, fh, err := Convert(IntegerHistogramTest, &Options{Scale: 0.5})
+	require.NoError(t, err)
+	require.Nil(t, h)
+	require.NotNil(t, fh)
+	// Per-bucket counts of {10, 5, 10, 0}, each halved.
+	require.Equal(t, []float64{5, 2.5, 5, 0}, fh.PositiveBuckets)
+	require.Equal(t, 12.5, fh.Count)
+	require.Equal(t, 99.9*0.5, fh.Sum)
+
+	// A scale of 1 is a no-op equal to the normal path.
+	h1, fh1, err := Convert(IntegerHistogramTest, &Options{Scale: 1})
+	require.NoError(t, err)
+	require.Nil(t, fh1)
+	hPlain, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+	require.Equal(t, hPlain, h1)
+}
+
+func TestConvertPreRated(t *testing.T) {
+	const interval = 15.0
+	rated := NewTempHistogram()
+	for le, count := range IntegerHistogramTest.BucketCounts {
+		rated.BucketCounts[le] = count / interval
+	}
+	rated.Count = IntegerHistogramTest.Count / interval
+	rated.SetSum(IntegerHistogramTest.Sum / interval)
+
+	h, fh, err := Convert(rated, &Options{PreRated: true, RateIntervalSeconds: interval})
+	require.NoError(t, err)
+	require.Nil(t, h)
+	require.NotNil(t, fh)
+	require.InDelta(t, 25, fh.Count, 1e-9)
+	require.InDelta(t, 99.9, fh.Sum, 1e-9)
+	// Reconstructed per-bucket counts {10, 5, 10, 0} within float tolerance.
+	expected := []float64{10, 5, 10, 0}
+	require.Len(t, fh.PositiveBuckets, len(expected))
+	for i, want := range expected {
+		require.InDelta(t, want, fh.PositiveBuckets[i], 1e-9, "bucket %d", i)
+	}
+
+	_, _, err = Convert(rated, &Options{PreRated: true})
+	require.ErrorContains(t, err, "positive RateIntervalSeconds")
+}
+
+func TestConvertStrictCount(t *testing.T) {
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, math.Inf(1): 20},
+		Count:        25,
+	}
+
+	_, _, err := Convert(th, &Options{StrictCount: true})
+	require.ErrorContains(t, err, "+Inf bucket count 20 is below the total count 25")
+
+	// The lenient default keeps today's behavior.
+	_, _, err = Convert(th, nil)
+	require.NoError(t, err)
+
+	// Equal values pass even in strict mode.
+	th.Count = 20
+	_, _, err = Convert(th, &Options{StrictCount: true})
+	require.NoError(t, err)
+}
+
+func TestConvertCompact(t *testing.T) {
+	// Interior empty buckets: per-bucket counts {5, 0, 0, 7, 0}.
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{1: 5, 2: 5, 3: 5, 4: 12, math.Inf(1): 12},
+		Count:        12,
+	}
+
+	dense, _, err := Convert(th, nil)
+	require.NoError(t, err)
+	require.Len(t, dense.PositiveSpans, 1)
+	require.Len(t, dense.PositiveBuckets, 5)
+
+	compact, _, err := Convert(th, &Options{Compact: true})
+	require.NoError(t, err)
+	require.Greater(t, len(compact.PositiveSpans), 1)
+	require.Len(t, compact.PositiveBuckets, 2)
+
+	// The logical contents are unchanged.
+	type pair struct{ le, count float64 }
+	collect := func(iter func(yield func(upperBound, count float64) bool)) []pair {
+		var pairs []pair
+		iter(func(upperBound, count float64) bool {
+			pairs = append(pairs, pair{upperBound, count})
+			return true
+		})
+		return pairs
+	}
+	require.Equal(t, collect(Buckets(dense)), collect(Buckets(compact)))
+}
+
+func TestConvertMinBuckets(t *testing.T) {
+	t.Run("1-bucket histogram is rejected", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 5, math.Inf(1): 5},
+			Count:        5,
+		}
+		th.SetSum(2)
+		_, _, err := Convert(th, &Options{MinBuckets: 2})
+		require.ErrorContains(t, err, "below the required minimum of 2")
+	})
+
+	t.Run("3-bucket histogram passes", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, &Options{MinBuckets: 2})
+		require.NoError(t, err)
+		require.NotNil(t, h)
+	})
+}
+
+func TestConvertInfThreshold(t *testing.T) {
+	// A broken exporter emitting both le="1e300" and le="+Inf" for the
+	// overflow bucket.
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{1: 5, 1e300: 10, math.Inf(1): 10},
+		Count:        10,
+	}
+	th.SetSum(42)
+
+	t.Run("threshold folds the near-infinite bound", func(t *testing.T) {
+		var warnings []ConvertWarning
+		h, _, err := Convert(th, &Options{InfThreshold: 1e200, Warnings: &warnings})
+		require.NoError(t, err)
+		require.Equal(t, []float64{1}, h.CustomValues)
+		require.Equal(t, uint64(10), h.Count)
+		require.Len(t, warnings, 1)
+		// The caller's map is left untouched.
+		require.Contains(t, th.BucketCounts, 1e300)
+	})
+
+	t.Run("default keeps the bounds distinct", func(t *testing.T) {
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, []float64{1, 1e300}, h.CustomValues)
+	})
+}
+
+func TestConvertTraceHook(t *testing.T) {
+	var phases []string
+	h, _, err := Convert(IntegerHistogramTest, &Options{TraceHook: func(phase string) {
+		phases = append(phases, phase)
+	}})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+	require.Equal(t, []string{"bounds", "deltas", "finalize", "done"}, phases)
+
+	// A failing conversion stops tracing at the phase that rejected it.
+	phases = nil
+	_, _, err = Convert(TempHistogram{}, &Options{TraceHook: func(phase string) {
+		phases = append(phases, phase)
+	}})
+	require.Error(t, err)
+	require.Empty(t, phases)
+
+	// A nil hook is a no-op.
+	h, _, err = Convert(IntegerHistogramTest, &Options{TraceHook: nil})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+}
+
+func TestConvertOriginalLeStrings(t *testing.T) {
+	th := NewTempHistogram()
+	for _, tc := range []struct {
+		le    string
+		count float64
+	}{
+		// Both spellings reformat differently from the source: "0.100"
+		// renders as "0.1" and "2.50" as "2.5".
+		{le: "0.100", count: 2},
+		{le: "2.50", count: 5},
+		{le: "+Inf", count: 5},
+	} {
+		bound, err := ParseUpperBound(tc.le)
+		require.NoError(t, err)
+		th.BucketCounts[bound] = tc.count
+		th.SetBucketString(bound, tc.le)
+	}
+	th.Count = 5
+	th.SetSum(1.25)
+
+	var stats ConvertStats
+	h, _, err := Convert(th, &Options{Stats: &stats})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	// Plain formatting loses the source spellings...
+	require.Equal(t, []string{"0.1", "2.5", "+Inf"}, FormatBounds(h))
+	// ...while the recorded originals survive the cycle byte for byte.
+	require.Equal(t, []string{"0.100", "2.50", "+Inf"}, FormatBoundsWithStrings(h, stats.LeStrings))
+
+	// Bounds without a recorded string fall back to standard formatting.
+	require.Equal(t, []string{"0.1", "2.5", "+Inf"}, FormatBoundsWithStrings(h, nil))
+}
+
+func TestConvertAdviseSchema(t *testing.T) {
+	t.Run("irregular bounds produce the advisory", func(t *testing.T) {
+		var warnings []ConvertWarning
+		_, _, err := Convert(IntegerHistogramTest, &Options{AdviseSchema: true, Warnings: &warnings})
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+		require.Contains(t, string(warnings[0]), "custom buckets")
+	})
+
+	t.Run("bounds matching a standard schema stay silent", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 5, 2: 8, 4: 12, math.Inf(1): 12},
+			Count:        12,
+		}
+		th.SetSum(7)
+		var warnings []ConvertWarning
+		_, _, err := Convert(th, &Options{AdviseSchema: true, Warnings: &warnings})
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+	})
+}
+
+func TestConvertBestEffort(t *testing.T) {
+	// The le=2 bucket dipped below its predecessor, e.g. due to a scrape
+	// race on the target.
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, 2: 5, 3: 25, math.Inf(1): 25},
+		Count:        25,
+	}
+	th.SetSum(99.9)
+
+	h, fh, err := Convert(th, &Options{BestEffort: true})
+	require.ErrorIs(t, err, ErrNonMonotonic)
+	require.ErrorContains(t, err, "best-effort conversion applied")
+	require.Nil(t, fh)
+	require.NotNil(t, h)
+
+	// The dip is clamped up to the running maximum: cumulative
+	// {10, 10, 25, 25}, i.e. per-bucket counts {10, 0, 15, 0}.
+	require.Equal(t, []int64{10, -10, 15, -15}, h.PositiveBuckets)
+	require.Equal(t, uint64(25), h.Count)
+	require.NoError(t, h.ValidateCustomBuckets())
+
+	// The caller's map is left untouched.
+	require.Equal(t, 5.0, th.BucketCounts[2])
+
+	// A monotonic input converts cleanly with the option set.
+	h, _, err = Convert(IntegerHistogramTest, &Options{BestEffort: true})
+	require.NoError(t, err)
+	require.NotNil(t, h)
+
+	// Hard errors still return nothing.
+	_, _, err = Convert(TempHistogram{}, &Options{BestEffort: true})
+	require.Error(t, err)
+}
+
+func TestConvertPreserveEmptyBuckets(t *testing.T) {
+	// Interior empty bucket: per-bucket counts {5, 0, 7, 0}.
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{1: 5, 2: 5, 3: 12, math.Inf(1): 12},
+		Count:        12,
+	}
+	th.SetSum(42)
+
+	preserved, _, err := Convert(th, &Options{PreserveEmptyBuckets: true})
+	require.NoError(t, err)
+	require.Equal(t, []histogram.Span{{Offset: 0, Length: 4}}, preserved.PositiveSpans)
+	require.Len(t, preserved.PositiveBuckets, 4)
+
+	// The option wins over Compact and TrimEmptyInf; the dense default layout
+	// is kept bit for bit.
+	dense, _, err := Convert(th, nil)
+	require.NoError(t, err)
+	pinned, _, err := Convert(th, &Options{Compact: true, TrimEmptyInf: true, PreserveEmptyBuckets: true})
+	require.NoError(t, err)
+	require.Equal(t, dense, pinned)
+}
+
+func TestConvertSplitZeroBucket(t *testing.T) {
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{0: 4, 1: 10, 2: 15, math.Inf(1): 15},
+		Count:        15,
+	}
+	th.SetSum(42)
+
+	t.Run("zero bound splits out of the histogram", func(t *testing.T) {
+		var stats ConvertStats
+		h, _, err := Convert(th, &Options{SplitZeroBucket: true, Stats: &stats})
+		require.NoError(t, err)
+		// The removed count is reported out-of-band; the histogram keeps the
+		// zero bucket unused, as the custom-buckets invariant demands.
+		require.Equal(t, 4.0, stats.ZeroCount)
+		require.Equal(t, uint64(0), h.ZeroCount)
+		require.Equal(t, []float64{1, 2}, h.CustomValues)
+		// Absolute bucket counts {6, 5, 0}, delta encoded; the total no
+		// longer includes the removed observations.
+		require.Equal(t, []int64{6, -1, -5}, h.PositiveBuckets)
+		require.Equal(t, uint64(11), h.Count)
+		require.False(t, stats.Lossless)
+		require.NoError(t, h.Validate())
+	})
+
+	t.Run("empty zero bucket combined with Compact", func(t *testing.T) {
+		// The split must run before compaction: Compact(0) drops the empty
+		// le=0 bucket first, and splitting afterwards would steal the 5
+		// observations of the (0,1] bucket.
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{0: 0, 1: 5, 2: 8, math.Inf(1): 8},
+			Count:        8,
+		}
+		th.SetSum(3)
+		var stats ConvertStats
+		h, _, err := Convert(th, &Options{SplitZeroBucket: true, Compact: true, Stats: &stats})
+		require.NoError(t, err)
+		require.Equal(t, 0.0, stats.ZeroCount)
+		require.Equal(t, uint64(0), h.ZeroCount)
+		require.Equal(t, uint64(8), h.Count)
+		require.Equal(t, []float64{1, 2}, h.CustomValues)
+		var first float64
+		Buckets(h)(func(_, count float64) bool {
+			first = count
+			return false
+		})
+		require.Equal(t, 5.0, first)
+		require.NoError(t, h.Validate())
+	})
+
+	t.Run("float path splits the same way", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{0: 1.5, 1: 4.5, math.Inf(1): 4.5},
+			Count:        4.5,
+			HasFloat:     true,
+		}
+		th.SetSum(2)
+		var stats ConvertStats
+		_, fh, err := Convert(th, &Options{SplitZeroBucket: true, Stats: &stats})
+		require.NoError(t, err)
+		require.Equal(t, 1.5, stats.ZeroCount)
+		require.Equal(t, 0.0, fh.ZeroCount)
+		require.Equal(t, 3.0, fh.Count)
+		require.Equal(t, []float64{1}, fh.CustomValues)
+		require.NoError(t, fh.Validate())
+	})
+
+	t.Run("disabled keeps the zero bound as a custom bucket", func(t *testing.T) {
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), h.ZeroCount)
+		require.Equal(t, []float64{0, 1, 2}, h.CustomValues)
+	})
+
+	t.Run("no zero bound leaves the zero bucket empty", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, &Options{SplitZeroBucket: true})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), h.ZeroCount)
+		require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+	})
+}
+
+func TestConvertOpenMetricsProfile(t *testing.T) {
+	om := &Options{Profile: ProfileOpenMetrics}
+
+	t.Run("compliant histogram accepted", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, om)
+		require.NoError(t, err)
+		require.Equal(t, uint64(25), h.Count)
+	})
+
+	t.Run("missing Inf bucket rejected", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, 2: 15},
+			Count:        15,
+		}
+		_, _, err := Convert(th, om)
+		require.ErrorIs(t, err, ErrNoInfBucket)
+
+		// The lenient default tolerates it as before.
+		_, _, err = Convert(th, nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("non-monotone buckets rejected", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, 2: 8, math.Inf(1): 10},
+			Count:        10,
+		}
+		_, _, err := Convert(th, om)
+		require.ErrorIs(t, err, ErrNonMonotonic)
+	})
+
+	t.Run("missing count rejected", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 10},
+		}
+		_, _, err := Convert(th, om)
+		require.ErrorContains(t, err, "no _count series")
+	})
+}
+
+func TestConvertErrorSentinels(t *testing.T) {
+	t.Run("ErrNonMonotonic", func(t *testing.T) {
+		err := VerifyCumulative([]float64{1, 2}, map[float64]float64{1: 10, 2: 8})
+		require.ErrorIs(t, err, ErrNonMonotonic)
+
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 20},
+			Count:        25,
+		}
+		_, _, err = Convert(th, &Options{StrictCount: true})
+		require.ErrorIs(t, err, ErrNonMonotonic)
+	})
+
+	t.Run("ErrNoInfBucket", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10},
+			Count:        10,
+		}
+		_, _, err := Convert(th, &Options{RequireInfBucket: true})
+		require.ErrorIs(t, err, ErrNoInfBucket)
+
+		// The lenient default converts the finite buckets as-is.
+		_, _, err = Convert(th, nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("ErrDuplicateConflict", func(t *testing.T) {
+		th := NewTempHistogram()
+		require.NoError(t, th.AddBucketCount(1, 10, DedupError))
+		err := th.AddBucketCount(1, 12, DedupError)
+		require.ErrorIs(t, err, ErrDuplicateConflict)
+	})
+
+	t.Run("ErrNonFiniteCount", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: math.NaN(), math.Inf(1): 10},
+		}
+		_, _, err := Convert(th, nil)
+		require.ErrorIs(t, err, ErrNonFiniteCount)
+
+		th = TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 10},
+			Count:        math.Inf(1),
+		}
+		_, _, err = Convert(th, nil)
+		require.ErrorIs(t, err, ErrNonFiniteCount)
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	t.Run("matches the full conversion's stats", func(t *testing.T) {
+		for _, th := range []TempHistogram{IntegerHistogramTest, MissingBucketTestInt} {
+			upperBounds, hBase, fhBase := processTestBounds(t, MissingBucketTestIntBounds)
+			var full ConvertStats
+			full.Lossless = true
+			NewHistogramWithOptions(th, upperBounds, hBase, fhBase, &Options{Stats: &full})
+
+			dry, err := DryRun(th, MissingBucketTestIntBounds)
+			require.NoError(t, err)
+			require.Equal(t, full.MissingBounds, dry.MissingBounds)
+			require.Equal(t, full.Lossless, dry.Lossless)
+			require.Equal(t, full.FloatAutoDetected, dry.FloatAutoDetected)
+		}
+	})
+
+	t.Run("float auto-detection", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 2.5, math.Inf(1): 5},
+			Count:        5,
+		}
+		stats, err := DryRun(th, []float64{1, math.Inf(1)})
+		require.NoError(t, err)
+		require.True(t, stats.FloatAutoDetected)
+	})
+
+	t.Run("non-finite count errors", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: math.NaN(), math.Inf(1): 5},
+		}
+		_, err := DryRun(th, []float64{1, math.Inf(1)})
+		require.ErrorIs(t, err, ErrNonFiniteCount)
+	})
+}
+
+func TestConvertFloatAutoDetected(t *testing.T) {
+	t.Run("undeclared fractional bucket is flagged", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 2.5, math.Inf(1): 5},
+			Count:        5,
+		}
+		var stats ConvertStats
+		h, fh, err := Convert(th, &Options{Stats: &stats})
+		require.NoError(t, err)
+		require.Nil(t, h)
+		require.NotNil(t, fh)
+		require.True(t, stats.FloatAutoDetected)
+	})
+
+	t.Run("declared float input is not flagged", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 2.5, math.Inf(1): 5},
+			Count:        5,
+			HasFloat:     true,
+		}
+		var stats ConvertStats
+		_, fh, err := Convert(th, &Options{Stats: &stats})
+		require.NoError(t, err)
+		require.NotNil(t, fh)
+		require.False(t, stats.FloatAutoDetected)
+	})
+
+	t.Run("integer input is not flagged", func(t *testing.T) {
+		var stats ConvertStats
+		h, _, err := Convert(IntegerHistogramTest, &Options{Stats: &stats})
+		require.NoError(t, err)
+		require.NotNil(t, h)
+		require.False(t, stats.FloatAutoDetected)
+	})
+}
+
+func TestConvertBucketLimit(t *testing.T) {
+	// Nine buckets with 5 observations each.
+	th := NewTempHistogram()
+	for i := 1; i <= 8; i++ {
+		th.BucketCounts[float64(i)] = float64(i * 5)
+	}
+	th.BucketCounts[math.Inf(1)] = 45
+	th.Count = 45
+
+	t.Run("reduce resolution to fit", func(t *testing.T) {
+		var warnings []ConvertWarning
+		h, _, err := Convert(th, &Options{BucketLimit: 5, ReduceResolution: true, Warnings: &warnings})
+		require.NoError(t, err)
+		require.Equal(t, []float64{1, 3, 5, 7}, h.CustomValues)
+		require.Equal(t, uint64(45), h.Count)
+		var cum []float64
+		Buckets(h)(func(upperBound, count float64) bool {
+			cum = append(cum, count)
+			return true
+		})
+		// Adjacent buckets merged, cumulative counts of the kept bounds intact.
+		require.Equal(t, []float64{5, 15, 25, 35, 45}, cum)
+		require.Len(t, warnings, 1)
+	})
+
+	t.Run("limit without reduction errors", func(t *testing.T) {
+		_, _, err := Convert(th, &Options{BucketLimit: 5})
+		require.Error(t, err)
+	})
+
+	t.Run("under the limit nothing changes", func(t *testing.T) {
+		h, _, err := Convert(th, &Options{BucketLimit: 9, ReduceResolution: true})
+		require.NoError(t, err)
+		require.Len(t, h.CustomValues, 8)
+	})
+}
+
+func TestConvertMaxAbsSum(t *testing.T) {
+	opts := &Options{MaxAbsSum: 1e15}
+
+	t.Run("normal sum passes", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, opts)
+		require.NoError(t, err)
+		require.Equal(t, 99.9, h.Sum)
+	})
+
+	t.Run("large but finite sum under the threshold passes", func(t *testing.T) {
+		th := IntegerHistogramTest
+		th.Sum = 1e14
+		_, _, err := Convert(th, opts)
+		require.NoError(t, err)
+	})
+
+	t.Run("absurd sum is rejected", func(t *testing.T) {
+		th := IntegerHistogramTest
+		th.Sum = 1e308
+		_, _, err := Convert(th, opts)
+		require.Error(t, err)
+
+		th.Sum = -1e308
+		_, _, err = Convert(th, opts)
+		require.Error(t, err)
+	})
+
+	t.Run("unset threshold accepts anything", func(t *testing.T) {
+		th := IntegerHistogramTest
+		th.Sum = 1e308
+		_, _, err := Convert(th, nil)
+		require.NoError(t, err)
+	})
+}
+
+func TestConvertTrimEmptyInf(t *testing.T) {
+	// le=3 and le=+Inf report the same cumulative count: the overflow delta
+	// is zero.
+	th := IntegerHistogramTest
+
+	t.Run("without trimming the empty overflow bucket stays", func(t *testing.T) {
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		require.Len(t, h.PositiveBuckets, 4)
+		require.Equal(t, uint64(25), h.Count)
+	})
+
+	t.Run("trimming drops it", func(t *testing.T) {
+		h, _, err := Convert(th, &Options{TrimEmptyInf: true})
+		require.NoError(t, err)
+		require.Len(t, h.PositiveBuckets, 3)
+		require.Equal(t, uint32(3), h.PositiveSpans[0].Length)
+		require.Equal(t, uint64(25), h.Count)
+		require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+		require.NoError(t, h.Validate())
+	})
+
+	t.Run("float path", func(t *testing.T) {
+		_, fh, err := Convert(th, &Options{TrimEmptyInf: true, Scale: 0.5})
+		require.NoError(t, err)
+		require.Len(t, fh.PositiveBuckets, 3)
+		require.Equal(t, 12.5, fh.Count)
+	})
+
+	t.Run("a non-empty overflow bucket is kept", func(t *testing.T) {
+		withOverflow := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 20},
+			Count:        20,
+		}
+		h, _, err := Convert(withOverflow, &Options{TrimEmptyInf: true})
+		require.NoError(t, err)
+		require.Len(t, h.PositiveBuckets, 2)
+	})
+}
+
+func TestConvertCounterResetHint(t *testing.T) {
+	for _, hint := range []histogram.CounterResetHint{
+		histogram.UnknownCounterReset,
+		histogram.CounterReset,
+		histogram.NotCounterReset,
+	} {
+		opts := &Options{CounterResetHint: hint}
+
+		h, fh, err := Convert(IntegerHistogramTest, opts)
+		require.NoError(t, err)
+		require.Nil(t, fh)
+		require.Equal(t, hint, h.CounterResetHint)
+
+		opts.Scale = 0.5
+		h, fh, err = Convert(IntegerHistogramTest, opts)
+		require.NoError(t, err)
+		require.Nil(t, h)
+		require.Equal(t, hint, fh.CounterResetHint)
+	}
+}
+
+func TestConvertMaxBuckets(t *testing.T) {
+	th := NewTempHistogram()
+	for i := 1; i <= 10; i++ {
+		th.BucketCounts[float64(i)] = float64(i)
+	}
+	th.Count = 10
+
+	t.Run("limit exceeded errors without merge", func(t *testing.T) {
+		_, _, err := Convert(th, &Options{MaxBuckets: 5})
+		require.ErrorContains(t, err, "exceeding the limit")
+	})
+
+	t.Run("merge folds counts into kept buckets", func(t *testing.T) {
+		var warnings []ConvertWarning
+		h, fh, err := Convert(th, &Options{MaxBuckets: 5, MergeMaxBuckets: true, Warnings: &warnings})
+		require.NoError(t, err)
+		require.Nil(t, fh)
+		require.Equal(t, []float64{2, 4, 6, 8, 10}, h.CustomValues)
+		require.Len(t, h.PositiveBuckets, 5)
+		// Each kept bucket absorbed the dropped one below it.
+		require.Equal(t, []int64{2, 0, 0, 0, 0}, h.PositiveBuckets) // Deltas of {2,2,2,2,2}.
+		require.Equal(t, uint64(10), h.Count)
+		require.Len(t, warnings, 1)
+	})
+
+	t.Run("within limit untouched", func(t *testing.T) {
+		h, _, err := Convert(IntegerHistogramTest, &Options{MaxBuckets: 5})
+		require.NoError(t, err)
+		require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+	})
+}
@@ -15,10 +15,13 @@ package convertnhcb
 
 import (
 	"fmt"
+	"maps"
 	"math"
 	"sort"
 	"strings"
 
+	"github.com/prometheus/common/model"
+
 	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 )
@@ -30,7 +33,30 @@ type TempHistogram struct {
 	BucketCounts map[float64]float64
 	Count        float64
 	Sum          float64
-	HasFloat     bool
+	// SumProvided records that Sum was explicitly set (via SetSum), which
+	// distinguishes a genuine zero sum from a target that exposed no _sum
+	// series at all. Convert turns an unprovided zero sum into NaN, the
+	// conventional "unknown sum" value.
+	SumProvided bool
+	HasFloat    bool
+	// BucketStrings optionally remembers the original textual le value for
+	// each parsed bound, recorded via SetBucketString. Formatting the parsed
+	// float does not always reproduce the source string (le="0.100" parses
+	// and reformats to "0.1"), so byte-exact round-trip tooling keeps the
+	// originals here. It is nil unless populated and is carried through
+	// conversion via ConvertStats.LeStrings.
+	BucketStrings map[float64]string
+	// CounterResetHint is the counter-reset hint to stamp onto the converted
+	// histogram, recorded by collectors that detect resets themselves, such
+	// as a Collection in AbsoluteResets mode. An explicitly configured
+	// Options.CounterResetHint takes precedence.
+	CounterResetHint histogram.CounterResetHint
+	// CreatedTimestamp is the timestamp in milliseconds from the metric's
+	// _created series, to be used as the counter reset / start time of the
+	// resulting native histogram. Zero means no _created series was seen.
+	// It is carried through conversion untouched and never contributes a
+	// bucket boundary.
+	CreatedTimestamp int64
 }
 
 // NewTempHistogram creates a new TempHistogram to
@@ -41,9 +67,141 @@ func NewTempHistogram() TempHistogram {
 	}
 }
 
+// NewTempHistogramSized is like NewTempHistogram, but pre-allocates the
+// BucketCounts map for n buckets, avoiding rehash churn in hot paths where
+// the bucket count of the incoming histogram is known up front. Behavior is
+// identical otherwise.
+func NewTempHistogramSized(n int) TempHistogram {
+	return TempHistogram{
+		BucketCounts: make(map[float64]float64, n),
+	}
+}
+
+// TempHistogramFromFloat reconstructs the classic cumulative view of a
+// converted custom-buckets float histogram, the inverse of NewHistogram at
+// the TempHistogram layer. BucketCounts is keyed by the CustomValues bounds
+// plus the implicit +Inf overflow, and HasFloat is set since the source
+// counts are floats.
+func TempHistogramFromFloat(fh *histogram.FloatHistogram) TempHistogram {
+	th := NewTempHistogram()
+	BucketsFloat(fh)(func(upperBound, count float64) bool {
+		th.BucketCounts[upperBound] = count
+		return true
+	})
+	th.Count = fh.Count
+	th.SetSum(fh.Sum)
+	th.HasFloat = true
+	return th
+}
+
+// SetSum records the histogram's sum and marks it as explicitly provided, so
+// that Convert preserves even a zero sum instead of treating it as absent.
+func (h *TempHistogram) SetSum(sum float64) {
+	h.Sum = sum
+	h.SumProvided = true
+}
+
+// Clone returns a deep copy of the histogram: the BucketCounts and
+// BucketStrings maps are cloned and the scalar fields copied, so mutations to
+// the clone never show through to the original. Pipelines use it to snapshot
+// an in-progress histogram, e.g. to diff against the next scrape.
+func (h TempHistogram) Clone() TempHistogram {
+	clone := h
+	clone.BucketCounts = maps.Clone(h.BucketCounts)
+	clone.BucketStrings = maps.Clone(h.BucketStrings)
+	return clone
+}
+
+// SetBucketString records the original textual le value the given bound was
+// parsed from, allocating the BucketStrings map on first use.
+func (h *TempHistogram) SetBucketString(le float64, s string) {
+	if h.BucketStrings == nil {
+		h.BucketStrings = map[float64]string{}
+	}
+	h.BucketStrings[le] = s
+}
+
+// DedupStrategy decides what happens when the same upper bound is reported
+// twice with conflicting cumulative counts, e.g. when a target double-emits a
+// bucket during a scrape race.
+type DedupStrategy int
+
+const (
+	// DedupFirst keeps the first count seen, matching the historical
+	// behavior of writing into BucketCounts directly.
+	DedupFirst DedupStrategy = iota
+	// DedupMax keeps the larger of the two counts. For cumulative counters
+	// the larger value is the more recent one.
+	DedupMax
+	// DedupError rejects the conflict.
+	DedupError
+)
+
+// AddBucketCount records the cumulative count for an upper bound, resolving a
+// conflict with an already-recorded count for the same bound according to the
+// given strategy. Re-reporting the identical count is never a conflict.
+func (h *TempHistogram) AddBucketCount(le, count float64, strategy DedupStrategy) error {
+	existing, ok := h.BucketCounts[le]
+	if !ok || existing == count {
+		h.BucketCounts[le] = count
+		return nil
+	}
+	switch strategy {
+	case DedupMax:
+		if count > existing {
+			h.BucketCounts[le] = count
+		}
+	case DedupError:
+		return fmt.Errorf("conflicting counts %g and %g for duplicate bucket le=%g: %w", existing, count, le, ErrDuplicateConflict)
+	}
+	return nil
+}
+
+// SortedBounds returns the upper bounds present in BucketCounts in ascending
+// order, with an Inf bound sorting last. It gives deterministic iteration
+// over the map for logging, diffing and test output.
+func (h TempHistogram) SortedBounds() []float64 {
+	bounds := make([]float64, 0, len(h.BucketCounts))
+	for le := range h.BucketCounts {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+// ForEachSorted calls f for every collected bucket in ascending bound order.
+func (h TempHistogram) ForEachSorted(f func(upperBound, count float64)) {
+	for _, le := range h.SortedBounds() {
+		f(le, h.BucketCounts[le])
+	}
+}
+
+// scaled returns a copy of the histogram with all cumulative bucket counts,
+// the total count and the sum multiplied by factor. Scaling the cumulative
+// values scales the resulting per-bucket counts by the same factor.
+func (h TempHistogram) scaled(factor float64) TempHistogram {
+	scaled := TempHistogram{
+		BucketCounts:     make(map[float64]float64, len(h.BucketCounts)),
+		Count:            h.Count * factor,
+		Sum:              h.Sum * factor,
+		SumProvided:      h.SumProvided,
+		HasFloat:         true,
+		BucketStrings:    h.BucketStrings,
+		CounterResetHint: h.CounterResetHint,
+		CreatedTimestamp: h.CreatedTimestamp,
+	}
+	for le, count := range h.BucketCounts {
+		scaled.BucketCounts[le] = count * factor
+	}
+	return scaled
+}
+
 func (h TempHistogram) getIntBucketCounts() (map[float64]int64, error) {
 	bucketCounts := map[float64]int64{}
 	for le, count := range h.BucketCounts {
+		if outsideInt64Range(count) {
+			return nil, fmt.Errorf("bucket count %g for le %g exceeds the int64 range", count, le)
+		}
 		intCount := int64(math.Round(count))
 		if float64(intCount) != count {
 			return nil, fmt.Errorf("bucket count %f for le %g is not an integer", count, le)
@@ -53,18 +211,84 @@ func (h TempHistogram) getIntBucketCounts() (map[float64]int64, error) {
 	return bucketCounts, nil
 }
 
+// outsideInt64Range reports whether the count cannot be stored as an int64,
+// which would make the integer path's delta subtraction wrap around into
+// negative buckets. float64(math.MaxInt64) rounds up to 2^63, hence the
+// inclusive comparison.
+func outsideInt64Range(count float64) bool {
+	return count >= float64(math.MaxInt64) || count < float64(math.MinInt64)
+}
+
+// VerifyCumulative checks that the collected cumulative bucket counts are
+// non-decreasing with the bound value, which in particular means the +Inf
+// bucket, when present, is at least as large as every finite bucket. Callers
+// can run it before committing to a conversion; the converter itself stays
+// lenient. Bounds without a reported count are skipped.
+func VerifyCumulative(upperBounds []float64, counts map[float64]float64) error {
+	sorted := make([]float64, len(upperBounds))
+	copy(sorted, upperBounds)
+	sort.Float64s(sorted)
+	prevLE := math.Inf(-1)
+	prevCount := 0.0
+	seen := false
+	for _, le := range sorted {
+		count, ok := counts[le]
+		if !ok {
+			continue
+		}
+		if seen && count < prevCount {
+			return fmt.Errorf("cumulative count %g at le=%g is below count %g at le=%g: %w", count, le, prevCount, prevLE, ErrNonMonotonic)
+		}
+		prevLE = le
+		prevCount = count
+		seen = true
+	}
+	return nil
+}
+
 // ProcessUpperBoundsAndCreateBaseHistogram prepares an integer native
 // histogram with custom buckets based on the provided upper bounds.
 // Everything is set except the bucket counts.
 // The sorted upper bounds are also returned.
+// The degenerate input consisting of only the +Inf bound is valid and yields
+// a histogram with a single open-ended bucket: empty CustomValues and a
+// one-length span, with all observations landing in the overflow bucket.
+// CustomValues is guaranteed to never contain +Inf: the input is sorted
+// first, so a +Inf appearing anywhere in it ends up as the implicit
+// terminator, and duplicate +Inf entries are collapsed into one even when
+// needsDedup is false.
 func ProcessUpperBoundsAndCreateBaseHistogram(upperBounds0 []float64, needsDedup bool) ([]float64, *histogram.Histogram) {
+	return ProcessUpperBoundsAndCreateBaseHistogramWithEpsilon(upperBounds0, needsDedup, 0)
+}
+
+// ProcessUpperBoundsAndCreateBaseHistograms is like
+// ProcessUpperBoundsAndCreateBaseHistogram, but additionally returns the
+// float base, for callers that only learn whether the series is integer or
+// float once the counts arrive. Both bases describe the same bucket layout
+// and share the CustomValues backing array.
+func ProcessUpperBoundsAndCreateBaseHistograms(upperBounds0 []float64, needsDedup bool) ([]float64, *histogram.Histogram, *histogram.FloatHistogram) {
+	upperBounds, hBase := ProcessUpperBoundsAndCreateBaseHistogram(upperBounds0, needsDedup)
+	fhBase := hBase.ToFloat(nil)
+	// ToFloat copies the custom values; point the float base back at the
+	// shared slice. Both bases treat it as immutable.
+	fhBase.CustomValues = hBase.CustomValues
+	return upperBounds, hBase, fhBase
+}
+
+// ProcessUpperBoundsAndCreateBaseHistogramWithEpsilon is like
+// ProcessUpperBoundsAndCreateBaseHistogram, but bounds within epsilon of
+// each other are collapsed to a single canonical value (the smallest of the
+// run). This absorbs last-ULP differences introduced by repeated text
+// parsing of the same bound. An epsilon of 0 means exact comparison,
+// identical to ProcessUpperBoundsAndCreateBaseHistogram.
+func ProcessUpperBoundsAndCreateBaseHistogramWithEpsilon(upperBounds0 []float64, needsDedup bool, epsilon float64) ([]float64, *histogram.Histogram) {
 	sort.Float64s(upperBounds0)
 	var upperBounds []float64
 	if needsDedup {
 		upperBounds = make([]float64, 0, len(upperBounds0))
 		prevLE := math.Inf(-1)
 		for _, le := range upperBounds0 {
-			if le != prevLE {
+			if le != prevLE && le-prevLE > epsilon {
 				upperBounds = append(upperBounds, le)
 				prevLE = le
 			}
@@ -72,6 +296,11 @@ func ProcessUpperBoundsAndCreateBaseHistogram(upperBounds0 []float64, needsDedup
 	} else {
 		upperBounds = upperBounds0
 	}
+	// Collapse duplicate +Inf entries into one so the bound below never ends
+	// up in CustomValues masquerading as a finite boundary.
+	for len(upperBounds) > 1 && math.IsInf(upperBounds[len(upperBounds)-2], 1) {
+		upperBounds = upperBounds[:len(upperBounds)-1]
+	}
 	var customBounds []float64
 	if upperBounds[len(upperBounds)-1] == math.Inf(1) {
 		customBounds = upperBounds[:len(upperBounds)-1]
@@ -90,62 +319,273 @@ func ProcessUpperBoundsAndCreateBaseHistogram(upperBounds0 []float64, needsDedup
 	}
 }
 
+// ConvertWarning is a human-readable note about a non-fatal fixup the
+// converter applied, e.g. a missing bucket that was assumed empty.
+type ConvertWarning string
+
 // NewHistogram fills the bucket counts in the provided histogram.Histogram
 // or histogram.FloatHistogram based on the provided temporary histogram and
 // upper bounds.
 func NewHistogram(histogram TempHistogram, upperBounds []float64, hBase *histogram.Histogram, fhBase *histogram.FloatHistogram) (*histogram.Histogram, *histogram.FloatHistogram) {
+	return NewHistogramWithWarnings(histogram, upperBounds, hBase, fhBase, nil)
+}
+
+// NewHistogramWithWarnings is like NewHistogram, but additionally appends a
+// note to warnings for every non-fatal fixup it applies, such as zero-filling
+// a missing bucket, falling back to a float histogram, or overriding the
+// computed total with the reported count. Passing nil for warnings gives
+// exactly the behavior of NewHistogram.
+// The integer path is only taken when all bucket counts and the total count
+// are integral, regardless of what the caller set HasFloat to. This way a
+// mislabeled histogram is auto-promoted to the float path instead of having
+// fractional values silently truncated.
+func NewHistogramWithWarnings(histogram TempHistogram, upperBounds []float64, hBase *histogram.Histogram, fhBase *histogram.FloatHistogram, warnings *[]ConvertWarning) (*histogram.Histogram, *histogram.FloatHistogram) {
+	return NewHistogramWithOptions(histogram, upperBounds, hBase, fhBase, &Options{Warnings: warnings})
+}
+
+// NewHistogramWithOptions is like NewHistogram, but additionally honors the
+// warning and stats collectors in opts. A nil opts behaves like NewHistogram.
+func NewHistogramWithOptions(histogram TempHistogram, upperBounds []float64, hBase *histogram.Histogram, fhBase *histogram.FloatHistogram, opts *Options) (*histogram.Histogram, *histogram.FloatHistogram) {
+	return newHistogram(histogram, upperBounds, hBase, fhBase, opts, true)
+}
+
+// ConvertAndValidate builds the histogram for the given bounds like
+// NewHistogram and immediately runs the custom-buckets validation on the
+// result, returning the first validation error instead of a silently broken
+// histogram. Exactly one of the returned histograms is non-nil on success,
+// depending on which path the input required.
+func ConvertAndValidate(th TempHistogram, bounds []float64) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	upperBounds, hBase, fhBase := ProcessUpperBoundsAndCreateBaseHistograms(append([]float64{}, bounds...), true)
+	h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+	if h != nil {
+		if err := h.ValidateCustomBuckets(); err != nil {
+			return nil, nil, err
+		}
+		return h, nil, nil
+	}
+	if err := fh.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return nil, fh, nil
+}
+
+// newHistogram implements NewHistogramWithOptions. When compact is false,
+// the result keeps the dense bucket layout of the base instead of being run
+// through Compact.
+func newHistogram(histogram TempHistogram, upperBounds []float64, hBase *histogram.Histogram, fhBase *histogram.FloatHistogram, opts *Options, compact bool) (*histogram.Histogram, *histogram.FloatHistogram) {
+	if opts.sortInput() && !sort.Float64sAreSorted(upperBounds) {
+		// Sort a copy and rebuild the bases so their bucket layout matches
+		// the new order; the caller's slices are left untouched.
+		upperBounds, hBase, fhBase = ProcessUpperBoundsAndCreateBaseHistograms(append([]float64{}, upperBounds...), true)
+	}
+	if opts != nil && opts.TrimLeadingMissing {
+		first := 0
+		for first < len(upperBounds) {
+			if _, ok := histogram.BucketCounts[upperBounds[first]]; ok {
+				break
+			}
+			first++
+		}
+		if first > 0 && first < len(upperBounds) {
+			opts.warn("trimmed %d leading unreported buckets", first)
+			upperBounds, hBase, fhBase = ProcessUpperBoundsAndCreateBaseHistograms(append([]float64{}, upperBounds[first:]...), false)
+		}
+	}
+	if opts.forceFloat() {
+		return nil, newFloatHistogram(histogram, upperBounds, histogram.BucketCounts, fhBase, opts, compact)
+	}
 	intBucketCounts, err := histogram.getIntBucketCounts()
 	if err != nil {
-		return nil, newFloatHistogram(histogram, upperBounds, histogram.BucketCounts, fhBase)
+		opts.warn("falling back to float histogram: %s", err)
+		if !histogram.HasFloat {
+			opts.floatAutoDetected()
+		}
+		return nil, newFloatHistogram(histogram, upperBounds, histogram.BucketCounts, fhBase, opts, compact)
+	}
+	if intCount := math.Round(histogram.Count); intCount != histogram.Count {
+		opts.warn("falling back to float histogram: count %f is not an integer", histogram.Count)
+		if !histogram.HasFloat {
+			opts.floatAutoDetected()
+		}
+		return nil, newFloatHistogram(histogram, upperBounds, histogram.BucketCounts, fhBase, opts, compact)
+	}
+	if outsideInt64Range(histogram.Count) {
+		opts.warn("falling back to float histogram: count %g exceeds the int64 range", histogram.Count)
+		return nil, newFloatHistogram(histogram, upperBounds, histogram.BucketCounts, fhBase, opts, compact)
+	}
+	return newIntegerHistogram(histogram, upperBounds, intBucketCounts, hBase, opts, compact), nil
+}
+
+func addWarning(warnings *[]ConvertWarning, format string, args ...interface{}) {
+	if warnings == nil {
+		return
 	}
-	return newIntegerHistogram(histogram, upperBounds, intBucketCounts, hBase), nil
+	*warnings = append(*warnings, ConvertWarning(fmt.Sprintf(format, args...)))
 }
 
-func newIntegerHistogram(histogram TempHistogram, upperBounds []float64, bucketCounts map[float64]int64, hBase *histogram.Histogram) *histogram.Histogram {
+// DeltaEncoder turns the absolute per-bucket counts of the integer path into
+// the histogram's bucket representation, writing into the dense span layout
+// the base provides. It is an extensibility seam for experimenting with
+// alternative encodings without forking the conversion; the spans may be
+// reshaped as long as the logical contents stay the same.
+type DeltaEncoder func(absCounts []int64, h *histogram.Histogram)
+
+// DefaultDeltaEncoder is the encoding NewHistogram applies when no custom
+// encoder is configured: each bucket stores the delta to its predecessor's
+// absolute count, as the chunk format expects.
+func DefaultDeltaEncoder(absCounts []int64, h *histogram.Histogram) {
+	if len(h.PositiveBuckets) == 0 {
+		return
+	}
+	h.PositiveBuckets[0] = absCounts[0]
+	for i := 1; i < len(h.PositiveBuckets); i++ {
+		h.PositiveBuckets[i] = absCounts[i] - absCounts[i-1]
+	}
+}
+
+func newIntegerHistogram(histogram TempHistogram, upperBounds []float64, bucketCounts map[float64]int64, hBase *histogram.Histogram, opts *Options, compact bool) *histogram.Histogram {
 	h := hBase.Copy()
+	// Fast path for tiny histograms (one real bucket plus +Inf): write the
+	// deltas directly and skip the intermediate absolute counts slice. The
+	// result is identical to the general path below. A custom encoder always
+	// takes the general path so it sees every histogram.
+	if len(upperBounds) <= 2 && len(upperBounds) == len(h.PositiveBuckets) && (opts == nil || opts.DeltaEncoder == nil) {
+		var total int64
+		c0 := lookupIntBucketCount(bucketCounts, upperBounds[0], opts)
+		checkIntPrecision(opts, c0)
+		opts.appendCumulative(float64(c0))
+		h.PositiveBuckets[0] = c0
+		total = c0
+		if len(upperBounds) == 2 {
+			c1 := lookupIntBucketCount(bucketCounts, upperBounds[1], opts)
+			checkIntPrecision(opts, c1-c0)
+			opts.appendCumulative(float64(c1))
+			// Delta-of-deltas: (c1-c0)-c0.
+			h.PositiveBuckets[1] = c1 - 2*c0
+			total = c1
+		}
+		return finishIntegerHistogram(histogram, h, total, opts, compact)
+	}
 	absBucketCounts := make([]int64, len(h.PositiveBuckets))
 	var prevCount, total int64
 	for i, le := range upperBounds {
 		currCount, exists := bucketCounts[le]
 		if !exists {
 			currCount = 0
+			opts.missingBound(le)
 		}
 		count := currCount - prevCount
+		checkIntPrecision(opts, count)
+		opts.appendCumulative(float64(currCount))
 		absBucketCounts[i] = count
 		total += count
 		prevCount = currCount
 	}
-	h.PositiveBuckets[0] = absBucketCounts[0]
-	for i := 1; i < len(h.PositiveBuckets); i++ {
-		h.PositiveBuckets[i] = absBucketCounts[i] - absBucketCounts[i-1]
+	encode := DeltaEncoder(DefaultDeltaEncoder)
+	if opts != nil && opts.DeltaEncoder != nil {
+		encode = opts.DeltaEncoder
 	}
+	encode(absBucketCounts, h)
+	return finishIntegerHistogram(histogram, h, total, opts, compact)
+}
+
+func lookupIntBucketCount(bucketCounts map[float64]int64, le float64, opts *Options) int64 {
+	count, exists := bucketCounts[le]
+	if !exists {
+		opts.missingBound(le)
+	}
+	return count
+}
+
+// maxSafeIntCount is the largest count that survives a round trip through
+// float64 unchanged. The inputs arrive as float64, so anything beyond it may
+// already have lost integer precision before conversion started.
+const maxSafeIntCount = int64(1) << 53
+
+// checkIntPrecision warns when a per-bucket delta or total leaves the range
+// in which float64 represents integers exactly. It is a guard against
+// silently-wrong conversions of extremely long-running counters, not a hard
+// failure.
+func checkIntPrecision(opts *Options, c int64) {
+	if c > maxSafeIntCount || c < -maxSafeIntCount {
+		opts.warn("count %d exceeds 2^53, the conversion may have lost precision; consider the float path", c)
+	}
+}
+
+func finishIntegerHistogram(histogram TempHistogram, h *histogram.Histogram, total int64, opts *Options, compact bool) *histogram.Histogram {
 	h.Sum = histogram.Sum
 	if histogram.Count != 0 {
+		if int64(histogram.Count) != total {
+			opts.warn("reported count %g overrides total %d computed from buckets", histogram.Count, total)
+			opts.markLossy()
+		}
 		total = int64(histogram.Count)
 	}
+	checkIntPrecision(opts, total)
 	h.Count = uint64(total)
-	return h.Compact(0)
+	h.CounterResetHint = opts.counterResetHint(combineResetHints(h.CounterResetHint, histogram.CounterResetHint))
+	if compact {
+		return h.Compact(0)
+	}
+	return h
 }
 
-func newFloatHistogram(histogram TempHistogram, upperBounds []float64, bucketCounts map[float64]float64, fhBase *histogram.FloatHistogram) *histogram.FloatHistogram {
+func newFloatHistogram(histogram TempHistogram, upperBounds []float64, bucketCounts map[float64]float64, fhBase *histogram.FloatHistogram, opts *Options, compact bool) *histogram.FloatHistogram {
 	fh := fhBase.Copy()
+	// Fast path for tiny histograms, mirroring newIntegerHistogram.
+	if len(upperBounds) <= 2 && len(upperBounds) == len(fh.PositiveBuckets) {
+		var total float64
+		c0 := lookupFloatBucketCount(bucketCounts, upperBounds[0], opts)
+		opts.appendCumulative(c0)
+		fh.PositiveBuckets[0] = c0
+		total = c0
+		if len(upperBounds) == 2 {
+			c1 := lookupFloatBucketCount(bucketCounts, upperBounds[1], opts)
+			opts.appendCumulative(c1)
+			fh.PositiveBuckets[1] = c1 - c0
+			total = c1
+		}
+		return finishFloatHistogram(histogram, fh, total, opts, compact)
+	}
 	var prevCount, total float64
 	for i, le := range upperBounds {
 		currCount, exists := bucketCounts[le]
 		if !exists {
 			currCount = 0
+			opts.missingBound(le)
 		}
 		count := currCount - prevCount
+		opts.appendCumulative(currCount)
 		fh.PositiveBuckets[i] = count
 		total += count
 		prevCount = currCount
 	}
+	return finishFloatHistogram(histogram, fh, total, opts, compact)
+}
+
+func lookupFloatBucketCount(bucketCounts map[float64]float64, le float64, opts *Options) float64 {
+	count, exists := bucketCounts[le]
+	if !exists {
+		opts.missingBound(le)
+	}
+	return count
+}
+
+func finishFloatHistogram(histogram TempHistogram, fh *histogram.FloatHistogram, total float64, opts *Options, compact bool) *histogram.FloatHistogram {
 	fh.Sum = histogram.Sum
 	if histogram.Count != 0 {
+		if histogram.Count != total {
+			opts.warn("reported count %g overrides total %g computed from buckets", histogram.Count, total)
+			opts.markLossy()
+		}
 		total = histogram.Count
 	}
 	fh.Count = total
-	return fh.Compact(0)
+	fh.CounterResetHint = opts.counterResetHint(combineResetHints(fh.CounterResetHint, histogram.CounterResetHint))
+	if compact {
+		return fh.Compact(0)
+	}
+	return fh
 }
 
 func GetHistogramMetricBase(m labels.Labels, suffix string) labels.Labels {
@@ -156,18 +596,141 @@ func GetHistogramMetricBase(m labels.Labels, suffix string) labels.Labels {
 		Labels()
 }
 
+// BuildComponentLabels is the inverse of GetHistogramMetricBase: it derives
+// the fully-qualified labels of one component series of a classic histogram
+// from its base labels, appending the given suffix to the metric name and,
+// when le is non-empty, setting the bucket label. It is mainly useful for
+// tooling that synthesizes classic series, e.g. test fixture generators.
+func BuildComponentLabels(base labels.Labels, suffix, le string) labels.Labels {
+	b := labels.NewBuilder(base).
+		Set(labels.MetricName, base.Get(labels.MetricName)+suffix)
+	if le != "" {
+		b.Set(labels.BucketLabel, le)
+	}
+	return b.Labels()
+}
+
+// GetHistogramMetricBaseWithOptions is like GetHistogramMetricBase, but
+// additionally applies the TransformBaseLabels hook from opts, if any, to the
+// computed base labels. It is the label-side counterpart of Convert for
+// callers emitting the converted series.
+func GetHistogramMetricBaseWithOptions(m labels.Labels, suffix string, opts *Options) labels.Labels {
+	base := GetHistogramMetricBase(m, suffix)
+	if opts != nil && opts.TransformBaseLabels != nil {
+		base = opts.TransformBaseLabels(base)
+	}
+	return base
+}
+
+// GetHistogramMetricBaseInto is equivalent to GetHistogramMetricBase, except
+// that it writes the rewritten labels into the caller-owned builder b instead
+// of allocating a fresh builder per call. The builder is reset to m first, so
+// a single builder can be reused across many series. Callers extract the
+// result via b.Labels().
+func GetHistogramMetricBaseInto(b *labels.Builder, m labels.Labels, suffix string) {
+	mName := m.Get(labels.MetricName)
+	b.Reset(m)
+	b.Set(labels.MetricName, strings.TrimSuffix(mName, suffix)).
+		Del(labels.BucketLabel)
+}
+
+// GetSummaryMetricBase is the classic summary counterpart of
+// GetHistogramMetricBase: it strips the given suffix from the metric name
+// and removes the quantile label instead of the le label.
+func GetSummaryMetricBase(m labels.Labels, suffix string) labels.Labels {
+	mName := m.Get(labels.MetricName)
+	return labels.NewBuilder(m).
+		Set(labels.MetricName, strings.TrimSuffix(mName, suffix)).
+		Del(model.QuantileLabel).
+		Labels()
+}
+
+// CanonicalBaseLabels returns the canonical base label set for any classic
+// histogram or summary component series: the metric name with its component
+// suffix stripped and the bucket ("le") or summary ("quantile") label
+// removed. All other labels are left intact, and the function is idempotent,
+// so it can safely be applied to labels that are already canonical.
+func CanonicalBaseLabels(lbls labels.Labels) labels.Labels {
+	name := lbls.Get(labels.MetricName)
+	return labels.NewBuilder(lbls).
+		Set(labels.MetricName, GetHistogramMetricBaseName(name)).
+		Del(labels.BucketLabel, model.QuantileLabel).
+		Labels()
+}
+
+// SuffixSet names the metric name suffixes identifying the component series
+// of a classic histogram. It exists for translated or non-standard datasets
+// whose exporters use other suffixes than the Prometheus defaults.
+type SuffixSet struct {
+	Bucket string
+	Sum    string
+	Count  string
+}
+
+// DefaultSuffixSet returns the standard Prometheus component suffixes.
+func DefaultSuffixSet() SuffixSet {
+	return SuffixSet{Bucket: "_bucket", Sum: "_sum", Count: "_count"}
+}
+
+// BaseName removes the set's bucket, sum or count suffix from the metric
+// name, like GetHistogramMetricBaseName does for the default suffixes.
+func (s SuffixSet) BaseName(name string) string {
+	if r, ok := strings.CutSuffix(name, s.Bucket); ok {
+		return r
+	}
+	if r, ok := strings.CutSuffix(name, s.Sum); ok {
+		return r
+	}
+	if r, ok := strings.CutSuffix(name, s.Count); ok {
+		return r
+	}
+	return name
+}
+
 // GetHistogramMetricBaseName removes the suffixes _bucket, _sum, _count from
 // the metric name. We specifically do not remove the _created suffix as that
 // should be removed by the caller.
 func GetHistogramMetricBaseName(s string) string {
-	if r, ok := strings.CutSuffix(s, "_bucket"); ok {
-		return r
+	return DefaultSuffixSet().BaseName(s)
+}
+
+// SeriesKind identifies which component of a classic histogram a series is.
+type SeriesKind int
+
+const (
+	// Bucket is a _bucket series carrying one cumulative bucket count.
+	Bucket SeriesKind = iota
+	// Sum is the _sum series.
+	Sum
+	// Count is the _count series.
+	Count
+)
+
+// IsClassicHistogramSeries reports whether the series looks like a component
+// of a classic histogram based on its metric name suffix, returning the base
+// metric name and which component it is. It is the cheap predicate pipelines
+// run before attempting conversion; a name without a recognized suffix
+// returns ok=false. Note the check is purely syntactic — a counter named
+// foo_count matches too.
+func IsClassicHistogramSeries(lbls labels.Labels) (base string, kind SeriesKind, ok bool) {
+	name := lbls.Get(labels.MetricName)
+	suffixes := DefaultSuffixSet()
+	if r, found := strings.CutSuffix(name, suffixes.Bucket); found {
+		return r, Bucket, true
 	}
-	if r, ok := strings.CutSuffix(s, "_sum"); ok {
-		return r
+	if r, found := strings.CutSuffix(name, suffixes.Sum); found {
+		return r, Sum, true
 	}
-	if r, ok := strings.CutSuffix(s, "_count"); ok {
-		return r
+	if r, found := strings.CutSuffix(name, suffixes.Count); found {
+		return r, Count, true
 	}
-	return s
+	return "", 0, false
+}
+
+// GetCreatedMetricBaseName removes the _created suffix from the metric name,
+// reporting whether it was present. Callers use it to route created-timestamp
+// samples to TempHistogram.CreatedTimestamp instead of mistaking them for
+// bucket series.
+func GetCreatedMetricBaseName(s string) (string, bool) {
+	return strings.CutSuffix(s, "_created")
 }
@@ -0,0 +1,818 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Shared classic histogram fixtures used throughout the package tests.
+var (
+	// IntegerHistogramTest is a fully specified classic integer histogram
+	// with cumulative bucket counts {10, 15, 25}.
+	IntegerHistogramTest = TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, 2: 15, 3: 25, math.Inf(1): 25},
+		Count:        25,
+		Sum:          99.9,
+		SumProvided:  true,
+	}
+	IntegerHistogramTestBounds = []float64{1, 2, 3, math.Inf(1)}
+
+	// MissingBucketTestInt is IntegerHistogramTest with the le=2 bucket
+	// absent from the scrape, while the bound set still contains it.
+	MissingBucketTestInt = TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, 3: 25, math.Inf(1): 25},
+		Count:        25,
+		Sum:          99.9,
+		SumProvided:  true,
+	}
+	MissingBucketTestIntBounds = []float64{1, 2, 3, math.Inf(1)}
+)
+
+// processTestBounds builds the bases for a fixture's bound set without
+// mutating the fixture slice.
+func processTestBounds(t testing.TB, bounds []float64) ([]float64, *histogram.Histogram, *histogram.FloatHistogram) {
+	t.Helper()
+	upperBounds, hBase := ProcessUpperBoundsAndCreateBaseHistogram(append([]float64{}, bounds...), true)
+	return upperBounds, hBase, hBase.ToFloat(nil)
+}
+
+var metricBaseFixtures = []struct {
+	labels labels.Labels
+	suffix string
+}{
+	{
+		labels: labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "0.5", "job", "test"),
+		suffix: "_bucket",
+	},
+	{
+		labels: labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "+Inf", "job", "test", "instance", "localhost:8080"),
+		suffix: "_bucket",
+	},
+	{
+		labels: labels.FromStrings(labels.MetricName, "request_duration_seconds_sum", "job", "test"),
+		suffix: "_sum",
+	},
+	{
+		labels: labels.FromStrings(labels.MetricName, "request_duration_seconds_count", "job", "test"),
+		suffix: "_count",
+	},
+	{
+		labels: labels.FromStrings(labels.MetricName, "request_duration_seconds"),
+		suffix: "_bucket",
+	},
+}
+
+func TestGetHistogramMetricBaseInto(t *testing.T) {
+	b := labels.NewBuilder(labels.EmptyLabels())
+	for _, fixture := range metricBaseFixtures {
+		expected := GetHistogramMetricBase(fixture.labels, fixture.suffix)
+		GetHistogramMetricBaseInto(b, fixture.labels, fixture.suffix)
+		require.Equal(t, expected, b.Labels())
+	}
+}
+
+func TestBuildComponentLabels(t *testing.T) {
+	base := labels.FromStrings(labels.MetricName, "request_duration_seconds", "job", "test")
+
+	bucket := BuildComponentLabels(base, "_bucket", "0.5")
+	require.Equal(t,
+		labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "0.5", "job", "test"),
+		bucket)
+
+	count := BuildComponentLabels(base, "_count", "")
+	require.Equal(t,
+		labels.FromStrings(labels.MetricName, "request_duration_seconds_count", "job", "test"),
+		count)
+
+	// Round-tripping through GetHistogramMetricBase recovers the base.
+	require.Equal(t, base, GetHistogramMetricBase(bucket, "_bucket"))
+	require.Equal(t, base, GetHistogramMetricBase(count, "_count"))
+}
+
+func TestGetHistogramMetricBaseWithOptions(t *testing.T) {
+	bucket := labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "0.5", "job", "test")
+
+	t.Run("nil transform matches plain base", func(t *testing.T) {
+		require.Equal(t,
+			GetHistogramMetricBase(bucket, "_bucket"),
+			GetHistogramMetricBaseWithOptions(bucket, "_bucket", nil))
+	})
+
+	t.Run("suffix rename runs after base-name logic", func(t *testing.T) {
+		opts := &Options{TransformBaseLabels: func(lbls labels.Labels) labels.Labels {
+			return labels.NewBuilder(lbls).
+				Set(labels.MetricName, lbls.Get(labels.MetricName)+"_nhcb").
+				Labels()
+		}}
+		base := GetHistogramMetricBaseWithOptions(bucket, "_bucket", opts)
+		// The _bucket suffix and le label were stripped before the transform
+		// appended its own suffix.
+		require.Equal(t,
+			labels.FromStrings(labels.MetricName, "request_duration_seconds_nhcb", "job", "test"),
+			base)
+	})
+
+	t.Run("constant label", func(t *testing.T) {
+		opts := &Options{TransformBaseLabels: func(lbls labels.Labels) labels.Labels {
+			return labels.NewBuilder(lbls).Set("converted", "true").Labels()
+		}}
+		base := GetHistogramMetricBaseWithOptions(bucket, "_bucket", opts)
+		require.Equal(t,
+			labels.FromStrings(labels.MetricName, "request_duration_seconds", "converted", "true", "job", "test"),
+			base)
+	})
+}
+
+func TestGetSummaryMetricBase(t *testing.T) {
+	base := labels.FromStrings(labels.MetricName, "rpc_duration_seconds", "job", "test")
+
+	quantile := labels.FromStrings(labels.MetricName, "rpc_duration_seconds", "quantile", "0.99", "job", "test")
+	require.Equal(t, base, GetSummaryMetricBase(quantile, ""))
+
+	sum := labels.FromStrings(labels.MetricName, "rpc_duration_seconds_sum", "job", "test")
+	require.Equal(t, base, GetSummaryMetricBase(sum, "_sum"))
+
+	count := labels.FromStrings(labels.MetricName, "rpc_duration_seconds_count", "job", "test")
+	require.Equal(t, base, GetSummaryMetricBase(count, "_count"))
+}
+
+func TestCanonicalBaseLabels(t *testing.T) {
+	base := labels.FromStrings(labels.MetricName, "request_duration_seconds", "job", "test")
+
+	bucket := labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "0.5", "job", "test")
+	require.Equal(t, base, CanonicalBaseLabels(bucket))
+
+	sum := labels.FromStrings(labels.MetricName, "request_duration_seconds_sum", "job", "test")
+	require.Equal(t, base, CanonicalBaseLabels(sum))
+
+	quantile := labels.FromStrings(labels.MetricName, "request_duration_seconds", "quantile", "0.99", "job", "test")
+	require.Equal(t, base, CanonicalBaseLabels(quantile))
+
+	// Applying the function twice must not change the result further.
+	require.Equal(t, base, CanonicalBaseLabels(CanonicalBaseLabels(bucket)))
+}
+
+func TestNewHistogramWithWarnings(t *testing.T) {
+	t.Run("missing bucket is reported", func(t *testing.T) {
+		upperBounds, hBase, fhBase := processTestBounds(t, MissingBucketTestIntBounds)
+		var warnings []ConvertWarning
+		h, fh := NewHistogramWithWarnings(MissingBucketTestInt, upperBounds, hBase, fhBase, &warnings)
+		require.NotNil(t, h)
+		require.Nil(t, fh)
+		require.Len(t, warnings, 1)
+		require.Contains(t, string(warnings[0]), "le=2")
+
+		// The result must be identical to the warning-less path.
+		hPlain, _ := NewHistogram(MissingBucketTestInt, upperBounds, hBase, fhBase)
+		require.Equal(t, hPlain, h)
+	})
+
+	t.Run("clean conversion yields no warnings", func(t *testing.T) {
+		upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+		var warnings []ConvertWarning
+		h, fh := NewHistogramWithWarnings(IntegerHistogramTest, upperBounds, hBase, fhBase, &warnings)
+		require.NotNil(t, h)
+		require.Nil(t, fh)
+		require.Empty(t, warnings)
+	})
+}
+
+func TestNewHistogramFloatAutoPromotion(t *testing.T) {
+	t.Run("fractional bucket count", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 2.5, math.Inf(1): 5},
+			Count:        5,
+			HasFloat:     false,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Nil(t, h)
+		require.NotNil(t, fh)
+		require.Equal(t, 2.5, fh.PositiveBuckets[0])
+	})
+
+	t.Run("fractional total count", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 2, math.Inf(1): 2},
+			Count:        2.5,
+			HasFloat:     false,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Nil(t, h)
+		require.NotNil(t, fh)
+		require.Equal(t, 2.5, fh.Count)
+	})
+}
+
+func TestTempHistogramSortedIteration(t *testing.T) {
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{math.Inf(1): 25, 0.5: 10, 2: 15, 1: 12},
+	}
+	expBounds := []float64{0.5, 1, 2, math.Inf(1)}
+	// The order must be stable across repeated calls despite map iteration
+	// being randomized.
+	for i := 0; i < 10; i++ {
+		require.Equal(t, expBounds, th.SortedBounds())
+
+		var bounds, counts []float64
+		th.ForEachSorted(func(upperBound, count float64) {
+			bounds = append(bounds, upperBound)
+			counts = append(counts, count)
+		})
+		require.Equal(t, expBounds, bounds)
+		require.Equal(t, []float64{10, 12, 15, 25}, counts)
+	}
+}
+
+func TestNewHistogramTinyFastPath(t *testing.T) {
+	t.Run("single bound", func(t *testing.T) {
+		th := TempHistogram{BucketCounts: map[float64]float64{1: 7}, Count: 7}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1})
+		h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Nil(t, fh)
+		require.NoError(t, h.Validate())
+		require.Equal(t, []int64{7}, h.PositiveBuckets)
+		require.Equal(t, uint64(7), h.Count)
+	})
+
+	t.Run("one bucket plus Inf", func(t *testing.T) {
+		th := TempHistogram{BucketCounts: map[float64]float64{1: 7, math.Inf(1): 9}, Count: 9}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Nil(t, fh)
+		require.NoError(t, h.Validate())
+		// Absolute bucket counts {7, 2}, delta encoded.
+		require.Equal(t, []int64{7, -5}, h.PositiveBuckets)
+		require.Equal(t, uint64(9), h.Count)
+	})
+
+	t.Run("one bucket plus Inf float", func(t *testing.T) {
+		th := TempHistogram{BucketCounts: map[float64]float64{1: 2.5, math.Inf(1): 4}, Count: 4}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Nil(t, h)
+		require.NoError(t, fh.Validate())
+		require.Equal(t, []float64{2.5, 1.5}, fh.PositiveBuckets)
+		require.Equal(t, 4.0, fh.Count)
+	})
+}
+
+func TestNewHistogramOnlyInfBucket(t *testing.T) {
+	// A target exposing just the +Inf bucket alongside _sum and _count is a
+	// valid, if degenerate, classic histogram: one open-ended bucket holding
+	// all observations.
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{math.Inf(1): 25},
+		Count:        25,
+		Sum:          99.9,
+	}
+	upperBounds, hBase, fhBase := processTestBounds(t, []float64{math.Inf(1)})
+	require.Empty(t, hBase.CustomValues)
+	require.Equal(t, []histogram.Span{{Offset: 0, Length: 1}}, hBase.PositiveSpans)
+
+	h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+	require.Nil(t, fh)
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.ValidateCustomBuckets())
+	require.Empty(t, h.CustomValues)
+	require.Equal(t, []int64{25}, h.PositiveBuckets)
+	require.Equal(t, uint64(25), h.Count)
+	require.Equal(t, 99.9, h.Sum)
+
+	// The high-level path agrees.
+	hConv, _, err := Convert(th, nil)
+	require.NoError(t, err)
+	require.Equal(t, h, hConv)
+}
+
+func TestAddBucketCount(t *testing.T) {
+	t.Run("first wins by default", func(t *testing.T) {
+		th := NewTempHistogram()
+		require.NoError(t, th.AddBucketCount(1, 10, DedupFirst))
+		require.NoError(t, th.AddBucketCount(1, 12, DedupFirst))
+		require.Equal(t, 10.0, th.BucketCounts[1])
+	})
+
+	t.Run("max keeps the larger count", func(t *testing.T) {
+		th := NewTempHistogram()
+		require.NoError(t, th.AddBucketCount(1, 10, DedupMax))
+		require.NoError(t, th.AddBucketCount(1, 12, DedupMax))
+		require.NoError(t, th.AddBucketCount(1, 11, DedupMax))
+		require.Equal(t, 12.0, th.BucketCounts[1])
+	})
+
+	t.Run("error rejects the conflict", func(t *testing.T) {
+		th := NewTempHistogram()
+		require.NoError(t, th.AddBucketCount(1, 10, DedupError))
+		// The identical count is not a conflict.
+		require.NoError(t, th.AddBucketCount(1, 10, DedupError))
+		err := th.AddBucketCount(1, 12, DedupError)
+		require.ErrorContains(t, err, "conflicting counts 10 and 12")
+		require.Equal(t, 10.0, th.BucketCounts[1])
+	})
+}
+
+func TestVerifyCumulative(t *testing.T) {
+	bounds := []float64{1, 2, 3, math.Inf(1)}
+
+	t.Run("monotone set passes", func(t *testing.T) {
+		require.NoError(t, VerifyCumulative(bounds, IntegerHistogramTest.BucketCounts))
+	})
+
+	t.Run("dip in the middle", func(t *testing.T) {
+		counts := map[float64]float64{1: 10, 2: 8, 3: 25, math.Inf(1): 25}
+		err := VerifyCumulative(bounds, counts)
+		require.ErrorContains(t, err, "count 8 at le=2 is below count 10 at le=1")
+	})
+
+	t.Run("Inf below a finite bucket", func(t *testing.T) {
+		counts := map[float64]float64{1: 10, 2: 15, 3: 25, math.Inf(1): 20}
+		err := VerifyCumulative(bounds, counts)
+		require.ErrorContains(t, err, "le=+Inf")
+	})
+
+	t.Run("missing buckets are skipped", func(t *testing.T) {
+		require.NoError(t, VerifyCumulative(bounds, MissingBucketTestInt.BucketCounts))
+	})
+}
+
+func TestProcessUpperBoundsAndCreateBaseHistograms(t *testing.T) {
+	upperBounds, hBase, fhBase := ProcessUpperBoundsAndCreateBaseHistograms(append([]float64{}, IntegerHistogramTestBounds...), true)
+	require.Equal(t, IntegerHistogramTestBounds, upperBounds)
+
+	// Both bases describe the same layout.
+	require.Equal(t, hBase.Schema, fhBase.Schema)
+	require.Equal(t, hBase.PositiveSpans, fhBase.PositiveSpans)
+	require.Len(t, fhBase.PositiveBuckets, len(hBase.PositiveBuckets))
+	require.Equal(t, hBase.CustomValues, fhBase.CustomValues)
+	// And share the custom values backing array.
+	require.Same(t, &hBase.CustomValues[0], &fhBase.CustomValues[0])
+
+	// The pair behaves exactly like independently built bases.
+	h, fh := NewHistogram(IntegerHistogramTest, upperBounds, hBase, fhBase)
+	require.Nil(t, fh)
+	expBounds, expBase, expFloatBase := processTestBounds(t, IntegerHistogramTestBounds)
+	expected, _ := NewHistogram(IntegerHistogramTest, expBounds, expBase, expFloatBase)
+	require.Equal(t, expected, h)
+}
+
+func TestNewHistogramNegativeBounds(t *testing.T) {
+	// The custom buckets schema admits arbitrary ascending reals, so bounds
+	// spanning negative values (e.g. temperature deltas) must convert like
+	// any others.
+	bounds := []float64{-5, -1, 0, 2, math.Inf(1)}
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{-5: 2, -1: 5, 0: 9, 2: 12, math.Inf(1): 12},
+		Count:        12,
+	}
+	th.SetSum(-7.5)
+
+	upperBounds, hBase := ProcessUpperBoundsAndCreateBaseHistogram(append([]float64{}, bounds...), true)
+	require.Equal(t, bounds, upperBounds)
+	require.Equal(t, []float64{-5, -1, 0, 2}, hBase.CustomValues)
+
+	h, fh := NewHistogram(th, upperBounds, hBase, hBase.ToFloat(nil))
+	require.Nil(t, fh)
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.ValidateCustomBuckets())
+	require.Equal(t, uint64(12), h.Count)
+	require.Equal(t, -7.5, h.Sum)
+	// Absolute bucket counts {2, 3, 4, 3}, delta encoded; the empty +Inf
+	// bucket is compacted away.
+	require.Equal(t, []int64{2, 1, 1, -1}, h.PositiveBuckets)
+
+	// Unsorted negative input sorts below the positives.
+	sorted, _ := ProcessUpperBoundsAndCreateBaseHistogram([]float64{2, -5, 0, -1}, false)
+	require.Equal(t, []float64{-5, -1, 0, 2}, sorted)
+}
+
+func TestNewHistogramIntPrecisionWarning(t *testing.T) {
+	t.Run("count above 2^53 warns", func(t *testing.T) {
+		big := math.Ldexp(1, 54) // Exactly representable, but past integer precision.
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: big, math.Inf(1): big},
+			Count:        big,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		var warnings []ConvertWarning
+		h, fh := NewHistogramWithWarnings(th, upperBounds, hBase, fhBase, &warnings)
+		require.NotNil(t, h)
+		require.Nil(t, fh)
+		require.NotEmpty(t, warnings)
+		require.Contains(t, string(warnings[0]), "2^53")
+	})
+
+	t.Run("normal range does not warn", func(t *testing.T) {
+		upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+		var warnings []ConvertWarning
+		NewHistogramWithWarnings(IntegerHistogramTest, upperBounds, hBase, fhBase, &warnings)
+		require.Empty(t, warnings)
+	})
+}
+
+func TestProcessUpperBoundsInfNeverInCustomValues(t *testing.T) {
+	t.Run("Inf mid slice is sorted to the end", func(t *testing.T) {
+		upperBounds, hBase := ProcessUpperBoundsAndCreateBaseHistogram([]float64{1, math.Inf(1), 2}, false)
+		require.Equal(t, []float64{1, 2, math.Inf(1)}, upperBounds)
+		require.Equal(t, []float64{1, 2}, hBase.CustomValues)
+	})
+
+	t.Run("duplicate Inf collapses without dedup", func(t *testing.T) {
+		upperBounds, hBase := ProcessUpperBoundsAndCreateBaseHistogram([]float64{math.Inf(1), 1, math.Inf(1)}, false)
+		require.Equal(t, []float64{1, math.Inf(1)}, upperBounds)
+		require.Equal(t, []float64{1}, hBase.CustomValues)
+		require.Len(t, hBase.PositiveBuckets, 2)
+	})
+}
+
+func BenchmarkNewHistogramSingleBucket(b *testing.B) {
+	th := TempHistogram{BucketCounts: map[float64]float64{1: 7, math.Inf(1): 9}, Count: 9}
+	upperBounds, hBase := ProcessUpperBoundsAndCreateBaseHistogram([]float64{1, math.Inf(1)}, true)
+	fhBase := hBase.ToFloat(nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewHistogram(th, upperBounds, hBase, fhBase)
+	}
+}
+
+func TestCreatedTimestamp(t *testing.T) {
+	th := NewTempHistogram()
+	// Route the samples of a scrape containing a _created line the way a
+	// caller would.
+	for _, s := range []struct {
+		name  string
+		le    float64
+		value float64
+	}{
+		{name: "m_bucket", le: 1, value: 10},
+		{name: "m_bucket", le: math.Inf(1), value: 10},
+		{name: "m_created", value: 1234567890},
+	} {
+		if _, ok := GetCreatedMetricBaseName(s.name); ok {
+			th.CreatedTimestamp = int64(s.value)
+			continue
+		}
+		th.BucketCounts[s.le] = s.value
+	}
+	require.Equal(t, int64(1234567890), th.CreatedTimestamp)
+
+	h, _, err := Convert(th, nil)
+	require.NoError(t, err)
+	// The created timestamp must not show up as a bucket boundary.
+	require.Equal(t, []float64{1}, h.CustomValues)
+
+	// _created is deliberately not stripped by GetHistogramMetricBaseName.
+	require.Equal(t, "m_created", GetHistogramMetricBaseName("m_created"))
+}
+
+func TestProcessUpperBoundsAndCreateBaseHistogramWithEpsilon(t *testing.T) {
+	bounds := []float64{0.1, 0.1 + 1e-16, math.Inf(1)}
+
+	exact, hExact := ProcessUpperBoundsAndCreateBaseHistogramWithEpsilon(append([]float64{}, bounds...), true, 0)
+	require.Len(t, exact, 3)
+	require.Len(t, hExact.CustomValues, 2)
+
+	collapsed, hCollapsed := ProcessUpperBoundsAndCreateBaseHistogramWithEpsilon(append([]float64{}, bounds...), true, 1e-12)
+	require.Equal(t, []float64{0.1, math.Inf(1)}, collapsed)
+	require.Equal(t, []float64{0.1}, hCollapsed.CustomValues)
+}
+
+func TestNewHistogramInt64Overflow(t *testing.T) {
+	t.Run("count near MaxInt64 stays on the int path", func(t *testing.T) {
+		near := float64(int64(1) << 62)
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: near, math.Inf(1): near},
+			Count:        near,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		h, fh := NewHistogram(th, upperBounds, hBase, fhBase)
+		require.Nil(t, fh)
+		require.Equal(t, uint64(near), h.Count)
+		require.GreaterOrEqual(t, h.PositiveBuckets[0], int64(0))
+	})
+
+	t.Run("count over MaxInt64 routes to the float path", func(t *testing.T) {
+		over := 1e19
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: over, math.Inf(1): over},
+			Count:        over,
+		}
+		upperBounds, hBase, fhBase := processTestBounds(t, []float64{1, math.Inf(1)})
+		var warnings []ConvertWarning
+		h, fh := NewHistogramWithWarnings(th, upperBounds, hBase, fhBase, &warnings)
+		require.Nil(t, h)
+		require.NotNil(t, fh)
+		require.Equal(t, over, fh.Count)
+		for _, b := range fh.PositiveBuckets {
+			require.GreaterOrEqual(t, b, 0.0)
+		}
+		require.NotEmpty(t, warnings)
+	})
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+	hWant, _ := NewHistogram(IntegerHistogramTest, upperBounds, hBase, fhBase)
+
+	t.Run("explicit default encoder matches", func(t *testing.T) {
+		h, fh := NewHistogramWithOptions(IntegerHistogramTest, upperBounds, hBase, fhBase, &Options{DeltaEncoder: DefaultDeltaEncoder})
+		require.Nil(t, fh)
+		require.Equal(t, hWant, h)
+	})
+
+	t.Run("custom encoder receives the absolute counts", func(t *testing.T) {
+		var seen []int64
+		encoder := func(absCounts []int64, h *histogram.Histogram) {
+			seen = append([]int64{}, absCounts...)
+			DefaultDeltaEncoder(absCounts, h)
+		}
+		h, _ := NewHistogramWithOptions(IntegerHistogramTest, upperBounds, hBase, fhBase, &Options{DeltaEncoder: encoder})
+		require.Equal(t, []int64{10, 5, 10, 0}, seen)
+		require.Equal(t, hWant, h)
+	})
+
+	t.Run("tiny histograms reach a custom encoder too", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, math.Inf(1): 25},
+			Count:        25,
+		}
+		called := false
+		encoder := func(absCounts []int64, h *histogram.Histogram) {
+			called = true
+			DefaultDeltaEncoder(absCounts, h)
+		}
+		bounds, hb, fhb := processTestBounds(t, []float64{1, math.Inf(1)})
+		NewHistogramWithOptions(th, bounds, hb, fhb, &Options{DeltaEncoder: encoder})
+		require.True(t, called)
+	})
+}
+
+func TestNewHistogramTrimLeadingMissing(t *testing.T) {
+	// The le=1 bucket was never reported.
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{2: 15, 3: 25, math.Inf(1): 25},
+		Count:        25,
+		Sum:          99.9,
+	}
+	upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+
+	t.Run("default zero-fills", func(t *testing.T) {
+		var warnings []ConvertWarning
+		h, _ := NewHistogramWithOptions(th, upperBounds, hBase, fhBase, &Options{Warnings: &warnings})
+		require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+		require.Len(t, warnings, 1)
+		require.Contains(t, string(warnings[0]), "le=1")
+	})
+
+	t.Run("trim starts at the first reported bucket", func(t *testing.T) {
+		var warnings []ConvertWarning
+		h, fh := NewHistogramWithOptions(th, upperBounds, hBase, fhBase, &Options{TrimLeadingMissing: true, Warnings: &warnings})
+		require.Nil(t, fh)
+		require.Equal(t, []float64{2, 3}, h.CustomValues)
+		require.Equal(t, uint64(25), h.Count)
+		require.NoError(t, h.ValidateCustomBuckets())
+		require.Len(t, warnings, 1)
+		require.Contains(t, string(warnings[0]), "trimmed 1 leading")
+	})
+}
+
+func TestTempHistogramFromFloat(t *testing.T) {
+	_, fh, err := Convert(IntegerHistogramTest, &Options{Scale: 0.5})
+	require.NoError(t, err)
+
+	th := TempHistogramFromFloat(fh)
+	require.True(t, th.HasFloat)
+	require.True(t, th.SumProvided)
+	require.Equal(t, map[float64]float64{1: 5, 2: 7.5, 3: 12.5, math.Inf(1): 12.5}, th.BucketCounts)
+
+	// The round trip through Convert reproduces the original.
+	_, roundTripped, err := Convert(th, nil)
+	require.NoError(t, err)
+	require.Equal(t, fh, roundTripped)
+}
+
+func TestIsClassicHistogramSeries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		wantBase string
+		wantKind SeriesKind
+		wantOK   bool
+	}{
+		{name: "request_duration_seconds_bucket", wantBase: "request_duration_seconds", wantKind: Bucket, wantOK: true},
+		{name: "request_duration_seconds_sum", wantBase: "request_duration_seconds", wantKind: Sum, wantOK: true},
+		{name: "request_duration_seconds_count", wantBase: "request_duration_seconds", wantKind: Count, wantOK: true},
+		{name: "up", wantOK: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lbls := labels.FromStrings(labels.MetricName, tc.name, "job", "test")
+			base, kind, ok := IsClassicHistogramSeries(lbls)
+			require.Equal(t, tc.wantOK, ok)
+			if tc.wantOK {
+				require.Equal(t, tc.wantBase, base)
+				require.Equal(t, tc.wantKind, kind)
+			}
+		})
+	}
+}
+
+func TestNewHistogramSortInput(t *testing.T) {
+	upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+	hWant, fhWant := NewHistogram(IntegerHistogramTest, upperBounds, hBase, fhBase)
+	require.Nil(t, fhWant)
+
+	shuffled := []float64{3, math.Inf(1), 1, 2}
+	h, fh := NewHistogramWithOptions(IntegerHistogramTest, shuffled, hBase, fhBase, &Options{SortInput: true})
+	require.Nil(t, fh)
+	require.Equal(t, hWant, h)
+	// The caller's slice is left in its original order.
+	require.Equal(t, []float64{3, math.Inf(1), 1, 2}, shuffled)
+}
+
+func TestConvertAndValidate(t *testing.T) {
+	t.Run("valid integer histogram", func(t *testing.T) {
+		h, fh, err := ConvertAndValidate(IntegerHistogramTest, IntegerHistogramTestBounds)
+		require.NoError(t, err)
+		require.Nil(t, fh)
+		require.NoError(t, h.ValidateCustomBuckets())
+	})
+
+	t.Run("valid float histogram", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 2.5, math.Inf(1): 5.5},
+			Count:        5.5,
+		}
+		h, fh, err := ConvertAndValidate(th, []float64{1, math.Inf(1)})
+		require.NoError(t, err)
+		require.Nil(t, h)
+		require.NoError(t, fh.Validate())
+	})
+
+	t.Run("non-monotonic input is rejected", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts: map[float64]float64{1: 10, 2: 5, math.Inf(1): 10},
+			Count:        10,
+		}
+		h, fh, err := ConvertAndValidate(th, []float64{1, 2, math.Inf(1)})
+		require.Error(t, err)
+		require.Nil(t, h)
+		require.Nil(t, fh)
+	})
+}
+
+// benchmarkHistogramInput builds a TempHistogram with the given number of
+// buckets (including +Inf) together with its processed bounds and base
+// histograms. Fractional counts force the float path.
+func benchmarkHistogramInput(buckets int, float bool) (TempHistogram, []float64, *histogram.Histogram, *histogram.FloatHistogram) {
+	bounds := make([]float64, 0, buckets)
+	th := NewTempHistogram()
+	cum := 0.0
+	for i := 0; i < buckets-1; i++ {
+		bound := float64(i + 1)
+		cum += float64(i % 7)
+		if float {
+			cum += 0.5
+		}
+		bounds = append(bounds, bound)
+		th.BucketCounts[bound] = cum
+	}
+	bounds = append(bounds, math.Inf(1))
+	th.BucketCounts[math.Inf(1)] = cum
+	th.Count = cum
+	th.SetSum(cum * 1.5)
+	upperBounds, hBase, fhBase := ProcessUpperBoundsAndCreateBaseHistograms(bounds, false)
+	return th, upperBounds, hBase, fhBase
+}
+
+func BenchmarkNewHistogram(b *testing.B) {
+	for _, path := range []struct {
+		name  string
+		float bool
+	}{
+		{name: "int", float: false},
+		{name: "float", float: true},
+	} {
+		b.Run(path.name, func(b *testing.B) {
+			for _, buckets := range []int{4, 16, 64} {
+				b.Run(fmt.Sprintf("buckets=%d", buckets), func(b *testing.B) {
+					th, upperBounds, hBase, fhBase := benchmarkHistogramInput(buckets, path.float)
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						NewHistogram(th, upperBounds, hBase, fhBase)
+					}
+				})
+			}
+		})
+	}
+
+	b.Run("missing bucket", func(b *testing.B) {
+		th, upperBounds, hBase, fhBase := benchmarkHistogramInput(16, false)
+		delete(th.BucketCounts, 8)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewHistogram(th, upperBounds, hBase, fhBase)
+		}
+	})
+}
+
+func BenchmarkGetHistogramMetricBase(b *testing.B) {
+	m := labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "0.5", "job", "test")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		GetHistogramMetricBase(m, "_bucket")
+	}
+}
+
+func BenchmarkGetHistogramMetricBaseInto(b *testing.B) {
+	m := labels.FromStrings(labels.MetricName, "request_duration_seconds_bucket", labels.BucketLabel, "0.5", "job", "test")
+	builder := labels.NewBuilder(labels.EmptyLabels())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		GetHistogramMetricBaseInto(builder, m, "_bucket")
+	}
+}
+
+func TestNewTempHistogramSized(t *testing.T) {
+	def := NewTempHistogram()
+	sized := NewTempHistogramSized(4)
+	for le, count := range IntegerHistogramTest.BucketCounts {
+		require.NoError(t, def.AddBucketCount(le, count, DedupFirst))
+		require.NoError(t, sized.AddBucketCount(le, count, DedupFirst))
+	}
+	def.Count = IntegerHistogramTest.Count
+	sized.Count = IntegerHistogramTest.Count
+	def.SetSum(IntegerHistogramTest.Sum)
+	sized.SetSum(IntegerHistogramTest.Sum)
+	require.Equal(t, def, sized)
+
+	hDef, _, err := Convert(def, nil)
+	require.NoError(t, err)
+	hSized, _, err := Convert(sized, nil)
+	require.NoError(t, err)
+	require.Equal(t, hDef, hSized)
+}
+
+func BenchmarkTempHistogramFill(b *testing.B) {
+	const numBuckets = 64
+	fill := func(th TempHistogram) {
+		for i := 0; i < numBuckets; i++ {
+			th.BucketCounts[float64(i+1)] = float64(i + 1)
+		}
+	}
+	b.Run("default", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fill(NewTempHistogram())
+		}
+	})
+	b.Run("sized", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fill(NewTempHistogramSized(numBuckets))
+		}
+	})
+}
+
+func TestTempHistogramClone(t *testing.T) {
+	th := NewTempHistogram()
+	th.BucketCounts[1] = 10
+	th.BucketCounts[math.Inf(1)] = 25
+	th.SetBucketString(1, "1.0")
+	th.Count = 25
+	th.SetSum(99.9)
+
+	clone := th.Clone()
+	require.Equal(t, th, clone)
+
+	// Mutating the clone's maps and scalars leaves the original unchanged.
+	clone.BucketCounts[1] = 99
+	clone.BucketCounts[2] = 7
+	clone.SetBucketString(1, "1.00")
+	clone.Count = 1
+	require.Equal(t, 10.0, th.BucketCounts[1])
+	require.NotContains(t, th.BucketCounts, 2.0)
+	require.Equal(t, "1.0", th.BucketStrings[1])
+	require.Equal(t, 25.0, th.Count)
+
+	// A nil BucketStrings map stays nil in the clone.
+	require.Nil(t, NewTempHistogram().Clone().BucketStrings)
+}
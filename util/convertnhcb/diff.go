@@ -0,0 +1,69 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import "sort"
+
+// BucketDelta is the change of a single bucket's cumulative count between two
+// TempHistograms.
+type BucketDelta struct {
+	UpperBound float64
+	Delta      float64
+}
+
+// TempHistogramDiff describes how one TempHistogram differs from another. It
+// is a diagnostics aid, e.g. for investigating bucket layout drift between
+// scrapes of the same target.
+type TempHistogramDiff struct {
+	// BucketDeltas holds the per-bound cumulative count changes for bounds
+	// present in both histograms, in ascending bound order. Unchanged buckets
+	// are omitted.
+	BucketDeltas []BucketDelta
+	// AddedBounds lists bounds present only in the second histogram, sorted.
+	AddedBounds []float64
+	// RemovedBounds lists bounds present only in the first histogram, sorted.
+	RemovedBounds []float64
+	CountDelta    float64
+	SumDelta      float64
+}
+
+// DiffTempHistograms compares two TempHistograms and reports which bucket
+// bounds appeared or disappeared and how the shared buckets' counts changed
+// going from a to b.
+func DiffTempHistograms(a, b TempHistogram) TempHistogramDiff {
+	diff := TempHistogramDiff{
+		CountDelta: b.Count - a.Count,
+		SumDelta:   b.Sum - a.Sum,
+	}
+	for le, aCount := range a.BucketCounts {
+		bCount, ok := b.BucketCounts[le]
+		switch {
+		case !ok:
+			diff.RemovedBounds = append(diff.RemovedBounds, le)
+		case bCount != aCount:
+			diff.BucketDeltas = append(diff.BucketDeltas, BucketDelta{UpperBound: le, Delta: bCount - aCount})
+		}
+	}
+	for le := range b.BucketCounts {
+		if _, ok := a.BucketCounts[le]; !ok {
+			diff.AddedBounds = append(diff.AddedBounds, le)
+		}
+	}
+	sort.Slice(diff.BucketDeltas, func(i, j int) bool {
+		return diff.BucketDeltas[i].UpperBound < diff.BucketDeltas[j].UpperBound
+	})
+	sort.Float64s(diff.AddedBounds)
+	sort.Float64s(diff.RemovedBounds)
+	return diff
+}
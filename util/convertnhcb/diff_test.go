@@ -0,0 +1,53 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffTempHistograms(t *testing.T) {
+	a := TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, 2: 15, math.Inf(1): 25},
+		Count:        25,
+		Sum:          99.9,
+	}
+	// b added the le=3 bucket and observed two more samples in le=2.
+	b := TempHistogram{
+		BucketCounts: map[float64]float64{1: 10, 2: 17, 3: 25, math.Inf(1): 27},
+		Count:        27,
+		Sum:          105.4,
+	}
+
+	diff := DiffTempHistograms(a, b)
+	require.Equal(t, []float64{3}, diff.AddedBounds)
+	require.Empty(t, diff.RemovedBounds)
+	require.Equal(t, []BucketDelta{
+		{UpperBound: 2, Delta: 2},
+		{UpperBound: math.Inf(1), Delta: 2},
+	}, diff.BucketDeltas)
+	require.Equal(t, 2.0, diff.CountDelta)
+	require.InDelta(t, 5.5, diff.SumDelta, 1e-9)
+
+	// The reverse diff reports the same bound as removed.
+	reverse := DiffTempHistograms(b, a)
+	require.Equal(t, []float64{3}, reverse.RemovedBounds)
+	require.Empty(t, reverse.AddedBounds)
+
+	// Identical histograms yield an empty diff.
+	require.Equal(t, TempHistogramDiff{}, DiffTempHistograms(a, a))
+}
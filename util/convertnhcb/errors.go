@@ -0,0 +1,33 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import "errors"
+
+// Sentinel errors wrapped by the conversion and validation failure paths, so
+// callers can route and count failures with errors.Is instead of matching
+// message text.
+var (
+	// ErrNonMonotonic marks cumulative counts that decrease with the bound,
+	// including a +Inf bucket or total count below a finite bucket.
+	ErrNonMonotonic = errors.New("cumulative counts are not monotonically non-decreasing")
+	// ErrNoInfBucket marks a histogram lacking the +Inf bucket where one is
+	// required.
+	ErrNoInfBucket = errors.New("histogram has no +Inf bucket")
+	// ErrDuplicateConflict marks a bound reported twice with different
+	// counts.
+	ErrDuplicateConflict = errors.New("duplicate bucket bound with conflicting counts")
+	// ErrNonFiniteCount marks a NaN or infinite bucket or total count.
+	ErrNonFiniteCount = errors.New("count is not finite")
+)
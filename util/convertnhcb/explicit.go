@@ -0,0 +1,92 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// FromExplicitBuckets converts a histogram arriving with per-bucket (not
+// cumulative) counts and a separately reported total, the shape OTel-style
+// explicit-bucket histograms deliver. bucketCounts[i] is the number of
+// observations in the bucket with upper bound bounds[i], aligned one to one,
+// with the +Inf overflow as the last bound like everywhere else in this
+// package. The counts must add up to count; a mismatch means a corrupt input
+// and is an error rather than something to paper over. Exactly one of the
+// returned histograms is non-nil, depending on whether the counts required
+// the float path.
+func FromExplicitBuckets(bucketCounts, bounds []float64, sum, count float64) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	if len(bucketCounts) != len(bounds) {
+		return nil, nil, fmt.Errorf("got %d bucket counts for %d bounds", len(bucketCounts), len(bounds))
+	}
+	th := NewTempHistogram()
+	cum := 0.0
+	for i, c := range bucketCounts {
+		if i > 0 && bounds[i] <= bounds[i-1] {
+			return nil, nil, fmt.Errorf("bounds are not strictly ascending at index %d", i)
+		}
+		cum += c
+		th.BucketCounts[bounds[i]] = cum
+	}
+	if cum != count {
+		return nil, nil, fmt.Errorf("bucket counts sum to %g, but the reported count is %g", cum, count)
+	}
+	th.Count = count
+	th.SetSum(sum)
+	h, fh, err := Convert(th, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, fh, nil
+}
+
+// FromBoundaries converts an OTel-style explicit-boundary histogram, where n
+// boundaries split the real line into n+1 bucket ranges, onto the upper-bound
+// bucket model: each boundary becomes the upper bound of the range it closes.
+// counts[i] is the number of observations in the i-th range, so counts must
+// have exactly one more entry than boundaries. The implicit edge ranges map
+// explicitly: counts[0], covering everything up to the first boundary, becomes
+// the first bucket, and the final count, covering everything from the last
+// boundary up, lands in the +Inf overflow bucket. An empty boundary list is
+// the degenerate single-range histogram and yields one open-ended bucket.
+// Exactly one of the returned histograms is non-nil, depending on whether the
+// counts required the float path.
+func FromBoundaries(boundaries, counts []float64, sum float64) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	if len(counts) != len(boundaries)+1 {
+		return nil, nil, fmt.Errorf("got %d bucket counts for %d boundaries, want %d", len(counts), len(boundaries), len(boundaries)+1)
+	}
+	th := NewTempHistogramSized(len(counts))
+	cum := 0.0
+	for i, c := range counts {
+		if i < len(boundaries) && i > 0 && boundaries[i] <= boundaries[i-1] {
+			return nil, nil, fmt.Errorf("boundaries are not strictly ascending at index %d", i)
+		}
+		cum += c
+		bound := math.Inf(1)
+		if i < len(boundaries) {
+			bound = boundaries[i]
+		}
+		th.BucketCounts[bound] = cum
+	}
+	th.Count = cum
+	th.SetSum(sum)
+	h, fh, err := Convert(th, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, fh, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromExplicitBuckets(t *testing.T) {
+	t.Run("valid input", func(t *testing.T) {
+		// Per-bucket counts matching the IntegerHistogramTest fixture's
+		// cumulative {10, 15, 25, 25}.
+		h, fh, err := FromExplicitBuckets(
+			[]float64{10, 5, 10, 0},
+			[]float64{1, 2, 3, math.Inf(1)},
+			99.9, 25)
+		require.NoError(t, err)
+		require.Nil(t, fh)
+		require.Equal(t, uint64(25), h.Count)
+		require.Equal(t, 99.9, h.Sum)
+
+		hWant, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		require.Equal(t, hWant, h)
+	})
+
+	t.Run("fractional counts take the float path", func(t *testing.T) {
+		h, fh, err := FromExplicitBuckets(
+			[]float64{2.5, 2},
+			[]float64{0.5, math.Inf(1)},
+			3.5, 4.5)
+		require.NoError(t, err)
+		require.Nil(t, h)
+		require.Equal(t, 4.5, fh.Count)
+	})
+
+	t.Run("count mismatch", func(t *testing.T) {
+		_, _, err := FromExplicitBuckets(
+			[]float64{10, 5},
+			[]float64{1, math.Inf(1)},
+			0, 20)
+		require.ErrorContains(t, err, "sum to 15")
+	})
+
+	t.Run("length mismatch", func(t *testing.T) {
+		_, _, err := FromExplicitBuckets([]float64{1}, []float64{1, math.Inf(1)}, 0, 1)
+		require.Error(t, err)
+	})
+
+	t.Run("unsorted bounds", func(t *testing.T) {
+		_, _, err := FromExplicitBuckets([]float64{1, 2}, []float64{2, 1}, 0, 3)
+		require.Error(t, err)
+	})
+}
+
+func TestFromBoundaries(t *testing.T) {
+	t.Run("3 boundaries produce a 4-bucket NHCB", func(t *testing.T) {
+		h, fh, err := FromBoundaries(
+			[]float64{1, 2, 3},
+			[]float64{10, 5, 10, 0},
+			99.9)
+		require.NoError(t, err)
+		require.Nil(t, fh)
+		require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+		require.Len(t, h.PositiveBuckets, 4)
+
+		// The boundaries close the same ranges the IntegerHistogramTest
+		// fixture's upper bounds do, so the outputs agree.
+		hWant, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		require.Equal(t, hWant, h)
+	})
+
+	t.Run("no boundaries yield the single open-ended bucket", func(t *testing.T) {
+		h, _, err := FromBoundaries(nil, []float64{7}, 3.5)
+		require.NoError(t, err)
+		require.Empty(t, h.CustomValues)
+		require.Equal(t, uint64(7), h.Count)
+	})
+
+	t.Run("counts length mismatch", func(t *testing.T) {
+		_, _, err := FromBoundaries([]float64{1, 2}, []float64{1, 2}, 0)
+		require.ErrorContains(t, err, "want 3")
+	})
+
+	t.Run("unsorted boundaries", func(t *testing.T) {
+		_, _, err := FromBoundaries([]float64{2, 1}, []float64{1, 2, 3}, 0)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"errors"
+	"math"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	dto "github.com/prometheus/prometheus/prompb/io/prometheus/client"
+)
+
+// FromProto converts a classic histogram arriving as one structured protobuf
+// message, as the scrape protobuf format delivers it, instead of separate
+// _bucket series. The cumulative proto buckets become the custom buckets; a
+// missing +Inf bucket is implied by the sample count, matching how the
+// protobuf exposition omits it. Float fields in the message (or fractional
+// counts) route to the float output, so exactly one of the returned
+// histograms is non-nil.
+func FromProto(hist *dto.Histogram) (*histogram.Histogram, *histogram.FloatHistogram, error) {
+	if hist == nil || len(hist.GetBucket()) == 0 {
+		return nil, nil, errors.New("proto histogram has no buckets")
+	}
+	th := NewTempHistogram()
+	for _, b := range hist.GetBucket() {
+		count := float64(b.GetCumulativeCount())
+		if b.GetCumulativeCountFloat() != 0 {
+			count = b.GetCumulativeCountFloat()
+			th.HasFloat = true
+		}
+		th.BucketCounts[b.GetUpperBound()] = count
+	}
+	th.Count = float64(hist.GetSampleCount())
+	if hist.GetSampleCountFloat() != 0 {
+		th.Count = hist.GetSampleCountFloat()
+		th.HasFloat = true
+	}
+	th.SetSum(hist.GetSampleSum())
+	if _, ok := th.BucketCounts[math.Inf(1)]; !ok {
+		th.BucketCounts[math.Inf(1)] = th.Count
+	}
+
+	upperBounds := th.SortedBounds()
+	bounds, hBase, fhBase := ProcessUpperBoundsAndCreateBaseHistograms(upperBounds, false)
+	h, fh := NewHistogram(th, bounds, hBase, fhBase)
+	return h, fh, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	dto "github.com/prometheus/prometheus/prompb/io/prometheus/client"
+)
+
+func TestFromProto(t *testing.T) {
+	t.Run("integer histogram", func(t *testing.T) {
+		hist := &dto.Histogram{
+			SampleCount: 25,
+			SampleSum:   99.9,
+			Bucket: []dto.Bucket{
+				{UpperBound: 1, CumulativeCount: 10},
+				{UpperBound: 2, CumulativeCount: 15},
+				{UpperBound: 3, CumulativeCount: 25},
+				{UpperBound: math.Inf(1), CumulativeCount: 25},
+			},
+		}
+		h, fh, err := FromProto(hist)
+		require.NoError(t, err)
+		require.Nil(t, fh)
+		require.Equal(t, uint64(25), h.Count)
+		require.Equal(t, 99.9, h.Sum)
+		require.Equal(t, []float64{1, 2, 3}, h.CustomValues)
+
+		// The same histogram as the series-based path.
+		hWant, _, err := Convert(IntegerHistogramTest, &Options{Compact: true})
+		require.NoError(t, err)
+		require.Equal(t, hWant, h)
+	})
+
+	t.Run("float counts route to the float path", func(t *testing.T) {
+		hist := &dto.Histogram{
+			SampleCountFloat: 4.5,
+			SampleSum:        3.5,
+			Bucket: []dto.Bucket{
+				{UpperBound: 0.5, CumulativeCountFloat: 2.5},
+				{UpperBound: math.Inf(1), CumulativeCountFloat: 4.5},
+			},
+		}
+		h, fh, err := FromProto(hist)
+		require.NoError(t, err)
+		require.Nil(t, h)
+		require.Equal(t, 4.5, fh.Count)
+		require.Equal(t, []float64{0.5}, fh.CustomValues)
+	})
+
+	t.Run("implicit +Inf bucket", func(t *testing.T) {
+		hist := &dto.Histogram{
+			SampleCount: 20,
+			SampleSum:   12,
+			Bucket: []dto.Bucket{
+				{UpperBound: 1, CumulativeCount: 10},
+			},
+		}
+		h, _, err := FromProto(hist)
+		require.NoError(t, err)
+		require.Equal(t, uint64(20), h.Count)
+		require.Equal(t, []float64{1}, h.CustomValues)
+	})
+
+	t.Run("no buckets", func(t *testing.T) {
+		_, _, err := FromProto(&dto.Histogram{SampleCount: 1})
+		require.Error(t, err)
+	})
+}
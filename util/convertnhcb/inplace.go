@@ -0,0 +1,88 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// NewHistogramInPlace is like NewHistogram, but instead of copying the base
+// it writes the bucket counts directly into hBase or fhBase and returns the
+// filled base. The result is identical to NewHistogram's, including span
+// compaction, but shares its memory with the base, so the caller must not use
+// the result and the base independently. The base's span and bucket slices
+// are re-shaped to the dense layout of upperBounds before filling (growing
+// them only when their capacity is insufficient), which makes a single base
+// reusable across many conversions of identically-bounded histograms without
+// per-conversion allocations.
+func NewHistogramInPlace(th TempHistogram, upperBounds []float64, hBase *histogram.Histogram, fhBase *histogram.FloatHistogram) (*histogram.Histogram, *histogram.FloatHistogram) {
+	intBucketCounts, err := th.getIntBucketCounts()
+	if err != nil || math.Round(th.Count) != th.Count {
+		return nil, newFloatHistogramInPlace(th, upperBounds, fhBase)
+	}
+	return newIntegerHistogramInPlace(th, upperBounds, intBucketCounts, hBase), nil
+}
+
+func newIntegerHistogramInPlace(th TempHistogram, upperBounds []float64, bucketCounts map[float64]int64, h *histogram.Histogram) *histogram.Histogram {
+	reshapeSpans(&h.PositiveSpans, len(upperBounds))
+	if cap(h.PositiveBuckets) >= len(upperBounds) {
+		h.PositiveBuckets = h.PositiveBuckets[:len(upperBounds)]
+	} else {
+		h.PositiveBuckets = make([]int64, len(upperBounds))
+	}
+	// Delta-of-deltas computed on the fly, without the intermediate absolute
+	// counts slice of the copying path. Every bucket is overwritten, so no
+	// stale values from a previous conversion can survive.
+	var prevCum, prevBucket, total int64
+	for i, le := range upperBounds {
+		cum := lookupIntBucketCount(bucketCounts, le, nil)
+		bucket := cum - prevCum
+		h.PositiveBuckets[i] = bucket - prevBucket
+		total += bucket
+		prevBucket = bucket
+		prevCum = cum
+	}
+	return finishIntegerHistogram(th, h, total, nil, true)
+}
+
+func newFloatHistogramInPlace(th TempHistogram, upperBounds []float64, fh *histogram.FloatHistogram) *histogram.FloatHistogram {
+	reshapeSpans(&fh.PositiveSpans, len(upperBounds))
+	if cap(fh.PositiveBuckets) >= len(upperBounds) {
+		fh.PositiveBuckets = fh.PositiveBuckets[:len(upperBounds)]
+	} else {
+		fh.PositiveBuckets = make([]float64, len(upperBounds))
+	}
+	var prevCum, total float64
+	for i, le := range upperBounds {
+		cum := lookupFloatBucketCount(th.BucketCounts, le, nil)
+		bucket := cum - prevCum
+		fh.PositiveBuckets[i] = bucket
+		total += bucket
+		prevCum = cum
+	}
+	return finishFloatHistogram(th, fh, total, nil, true)
+}
+
+// reshapeSpans restores the single dense span covering n buckets that a
+// previous Compact may have split or truncated.
+func reshapeSpans(spans *[]histogram.Span, n int) {
+	if cap(*spans) >= 1 {
+		*spans = (*spans)[:1]
+	} else {
+		*spans = make([]histogram.Span, 1)
+	}
+	(*spans)[0] = histogram.Span{Offset: 0, Length: uint32(n)}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHistogramInPlace(t *testing.T) {
+	upperBounds, hBase, fhBase := processTestBounds(t, IntegerHistogramTestBounds)
+	expected, _ := NewHistogram(IntegerHistogramTest, upperBounds, hBase, fhBase)
+
+	h, fh := NewHistogramInPlace(IntegerHistogramTest, upperBounds, hBase, fhBase)
+	require.Nil(t, fh)
+	require.Equal(t, expected, h)
+	// The result is the base itself, not a copy.
+	require.Same(t, hBase, h)
+
+	// A second conversion with different counts through the same base must
+	// not see stale values from the first, despite the intervening Compact.
+	th2 := TempHistogram{
+		BucketCounts: map[float64]float64{1: 3, 2: 3, 3: 4, math.Inf(1): 4},
+		Count:        4,
+		Sum:          7.5,
+	}
+	upperBounds2, hBase2, fhBase2 := processTestBounds(t, IntegerHistogramTestBounds)
+	expected2, _ := NewHistogram(th2, upperBounds2, hBase2, fhBase2)
+	h2, _ := NewHistogramInPlace(th2, upperBounds, hBase, fhBase)
+	require.Equal(t, expected2, h2)
+
+	// Float inputs take the float base, matching the copying path.
+	thFloat := TempHistogram{
+		BucketCounts: map[float64]float64{1: 2.5, 2: 3, 3: 4, math.Inf(1): 4},
+		Count:        4,
+	}
+	hFloat, expectedFloat := NewHistogram(thFloat, upperBounds2, hBase2, fhBase2)
+	require.Nil(t, hFloat)
+	hInPlace, fhInPlace := NewHistogramInPlace(thFloat, upperBounds, hBase, fhBase)
+	require.Nil(t, hInPlace)
+	require.Equal(t, expectedFloat, fhInPlace)
+	require.Same(t, fhBase, fhInPlace)
+}
+
+func BenchmarkNewHistogramInPlace(b *testing.B) {
+	upperBounds, hBase, fhBase := processTestBounds(b, IntegerHistogramTestBounds)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewHistogramInPlace(IntegerHistogramTest, upperBounds, hBase, fhBase)
+	}
+}
+
+func BenchmarkNewHistogramCopying(b *testing.B) {
+	upperBounds, hBase, fhBase := processTestBounds(b, IntegerHistogramTestBounds)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewHistogram(IntegerHistogramTest, upperBounds, hBase, fhBase)
+	}
+}
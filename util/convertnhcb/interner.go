@@ -0,0 +1,96 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// BoundsInterner deduplicates bucket bound slices across series. Many series
+// scraped from the same target share identical bucket layouts, and interning
+// lets all their converted histograms point at one shared CustomValues
+// backing array instead of each allocating its own copy.
+//
+// Entries are keyed by a hash of the bound values; hash collisions are
+// resolved by full comparison. Lookups of already-interned bounds only take a
+// read lock, so concurrent scrape goroutines interning the same layouts do
+// not serialize on the common path.
+//
+// The returned slices are shared and must be treated as immutable by
+// callers.
+type BoundsInterner struct {
+	mtx sync.RWMutex
+	// bounds maps a hash to all distinct bound sets sharing it. The inner
+	// slice almost always has one element.
+	bounds map[uint64][][]float64
+}
+
+// NewBoundsInterner creates an empty BoundsInterner.
+func NewBoundsInterner() *BoundsInterner {
+	return &BoundsInterner{bounds: map[uint64][][]float64{}}
+}
+
+// Intern returns a shared, immutable slice equal to bounds. The first call
+// for a given set of bounds stores a private copy; subsequent calls with an
+// equal set return the same backing array. It is safe for concurrent use.
+func (in *BoundsInterner) Intern(bounds []float64) []float64 {
+	if len(bounds) == 0 {
+		return bounds
+	}
+	key := boundsHash(bounds)
+	in.mtx.RLock()
+	shared, ok := lookupBounds(in.bounds[key], bounds)
+	in.mtx.RUnlock()
+	if ok {
+		return shared
+	}
+	in.mtx.Lock()
+	defer in.mtx.Unlock()
+	// Another goroutine may have inserted between the two locks.
+	if shared, ok := lookupBounds(in.bounds[key], bounds); ok {
+		return shared
+	}
+	shared = slices.Clone(bounds)
+	in.bounds[key] = append(in.bounds[key], shared)
+	return shared
+}
+
+// lookupBounds finds the entry equal to bounds among the sets sharing a hash.
+func lookupBounds(candidates [][]float64, bounds []float64) ([]float64, bool) {
+	for _, c := range candidates {
+		if slices.Equal(c, bounds) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// boundsHash hashes the bound values by their bit patterns.
+func boundsHash(bounds []float64) uint64 {
+	var (
+		d xxhash.Digest
+		b [8]byte
+	)
+	d.Reset()
+	for _, bound := range bounds {
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(bound))
+		_, _ = d.Write(b[:])
+	}
+	return d.Sum64()
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundsInterner(t *testing.T) {
+	in := NewBoundsInterner()
+
+	a := in.Intern([]float64{1, 2, 3})
+	b := in.Intern([]float64{1, 2, 3})
+	require.Equal(t, a, b)
+	require.Same(t, &a[0], &b[0], "equal bound sets must share a backing array")
+
+	c := in.Intern([]float64{1, 2, 4})
+	require.NotSame(t, &a[0], &c[0])
+
+	// The interner holds its own copy, detached from the caller's slice.
+	input := []float64{5, 6}
+	d := in.Intern(input)
+	input[0] = 99
+	require.Equal(t, []float64{5, 6}, d)
+}
+
+func TestBoundsInternerConcurrent(t *testing.T) {
+	const (
+		goroutines = 16
+		sets       = 20
+		rounds     = 50
+	)
+	in := NewBoundsInterner()
+	results := make([][][]float64, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			results[g] = make([][]float64, sets)
+			for r := 0; r < rounds; r++ {
+				for s := 0; s < sets; s++ {
+					// Overlapping sets: every goroutine interns all of them.
+					results[g][s] = in.Intern([]float64{float64(s), float64(s) + 1, float64(s) + 2})
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for s := 0; s < sets; s++ {
+		want := results[0][s]
+		require.Equal(t, []float64{float64(s), float64(s) + 1, float64(s) + 2}, want)
+		for g := 1; g < goroutines; g++ {
+			require.Same(t, &want[0], &results[g][s][0], "goroutines must share one backing array per bound set")
+		}
+	}
+}
+
+func TestConvertWithInterner(t *testing.T) {
+	in := NewBoundsInterner()
+	opts := &Options{Interner: in}
+
+	h1, _, err := Convert(IntegerHistogramTest, opts)
+	require.NoError(t, err)
+	h2, _, err := Convert(IntegerHistogramTest, opts)
+	require.NoError(t, err)
+	require.Equal(t, h1.CustomValues, h2.CustomValues)
+	require.Same(t, &h1.CustomValues[0], &h2.CustomValues[0])
+}
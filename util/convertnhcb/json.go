@@ -0,0 +1,99 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// tempHistogramJSON is the wire form of a TempHistogram. As in Prometheus's
+// HTTP APIs, sample values are encoded as strings so that NaN and the
+// infinities survive the trip, and buckets are emitted in ascending bound
+// order for stable output.
+type tempHistogramJSON struct {
+	Buckets          []tempBucketJSON `json:"buckets"`
+	Count            string           `json:"count"`
+	Sum              string           `json:"sum"`
+	SumProvided      bool             `json:"sumProvided,omitempty"`
+	HasFloat         bool             `json:"hasFloat,omitempty"`
+	CreatedTimestamp int64            `json:"createdTimestamp,omitempty"`
+}
+
+type tempBucketJSON struct {
+	Le    string `json:"le"`
+	Count string `json:"count"`
+}
+
+// MarshalJSON implements json.Marshaler, mainly for debug dumps and test
+// fixtures of in-flight conversions.
+func (h TempHistogram) MarshalJSON() ([]byte, error) {
+	out := tempHistogramJSON{
+		Buckets:          make([]tempBucketJSON, 0, len(h.BucketCounts)),
+		Count:            formatValue(h.Count),
+		Sum:              formatValue(h.Sum),
+		SumProvided:      h.SumProvided,
+		HasFloat:         h.HasFloat,
+		CreatedTimestamp: h.CreatedTimestamp,
+	}
+	h.ForEachSorted(func(upperBound, count float64) {
+		out.Buckets = append(out.Buckets, tempBucketJSON{
+			Le:    formatBound(upperBound),
+			Count: formatValue(count),
+		})
+	})
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, the inverse of MarshalJSON.
+func (h *TempHistogram) UnmarshalJSON(data []byte) error {
+	var in tempHistogramJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	count, err := strconv.ParseFloat(in.Count, 64)
+	if err != nil {
+		return fmt.Errorf("invalid count: %w", err)
+	}
+	sum, err := strconv.ParseFloat(in.Sum, 64)
+	if err != nil {
+		return fmt.Errorf("invalid sum: %w", err)
+	}
+	*h = TempHistogram{
+		BucketCounts:     make(map[float64]float64, len(in.Buckets)),
+		Count:            count,
+		Sum:              sum,
+		SumProvided:      in.SumProvided,
+		HasFloat:         in.HasFloat,
+		CreatedTimestamp: in.CreatedTimestamp,
+	}
+	for _, b := range in.Buckets {
+		le, err := ParseUpperBound(b.Le)
+		if err != nil {
+			return err
+		}
+		bucketCount, err := strconv.ParseFloat(b.Count, 64)
+		if err != nil {
+			return fmt.Errorf("invalid count for bucket le=%s: %w", b.Le, err)
+		}
+		h.BucketCounts[le] = bucketCount
+	}
+	return nil
+}
+
+// formatValue renders a sample value the way FormatBounds renders bounds.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
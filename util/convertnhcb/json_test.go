@@ -0,0 +1,70 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempHistogramJSONRoundTrip(t *testing.T) {
+	t.Run("fixture", func(t *testing.T) {
+		data, err := json.Marshal(IntegerHistogramTest)
+		require.NoError(t, err)
+		// Buckets come out in ascending bound order.
+		require.JSONEq(t, `{
+			"buckets": [
+				{"le": "1", "count": "10"},
+				{"le": "2", "count": "15"},
+				{"le": "3", "count": "25"},
+				{"le": "+Inf", "count": "25"}
+			],
+			"count": "25",
+			"sum": "99.9",
+			"sumProvided": true
+		}`, string(data))
+
+		var back TempHistogram
+		require.NoError(t, json.Unmarshal(data, &back))
+		require.Equal(t, IntegerHistogramTest, back)
+	})
+
+	t.Run("NaN sum and created timestamp", func(t *testing.T) {
+		th := TempHistogram{
+			BucketCounts:     map[float64]float64{0.5: 1.5, math.Inf(1): 2.5},
+			Count:            2.5,
+			Sum:              math.NaN(),
+			HasFloat:         true,
+			CreatedTimestamp: 1234567890,
+		}
+		data, err := json.Marshal(th)
+		require.NoError(t, err)
+
+		var back TempHistogram
+		require.NoError(t, json.Unmarshal(data, &back))
+		require.True(t, math.IsNaN(back.Sum))
+		require.Equal(t, th.BucketCounts, back.BucketCounts)
+		require.Equal(t, th.Count, back.Count)
+		require.Equal(t, th.CreatedTimestamp, back.CreatedTimestamp)
+		require.True(t, back.HasFloat)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		var th TempHistogram
+		require.Error(t, json.Unmarshal([]byte(`{"buckets":[{"le":"bogus","count":"1"}],"count":"1","sum":"0"}`), &th))
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// UnionBounds merges two sorted upper bound sets into their sorted, deduped
+// union, including at most one +Inf terminator. Aligning two histograms with
+// overlapping-but-different layouts onto the union lets the sum helpers
+// combine them. Both inputs must already be sorted; the merge avoids the
+// allocation churn of a map-based union.
+func UnionBounds(a, b []float64) []float64 {
+	out := make([]float64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var v float64
+		switch {
+		case j >= len(b):
+			v, i = a[i], i+1
+		case i >= len(a):
+			v, j = b[j], j+1
+		case a[i] <= b[j]:
+			v, i = a[i], i+1
+		default:
+			v, j = b[j], j+1
+		}
+		if len(out) == 0 || out[len(out)-1] != v {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// SumHistograms adds the given custom-buckets histograms elementwise into a
+// single histogram, e.g. for downsampling. All inputs must share the exact
+// same bucket layout: schema, custom values and spans. A layout mismatch is
+// an error rather than silently summing buckets with different meanings.
+// The inputs are not modified.
+func SumHistograms(hs []*histogram.Histogram) (*histogram.Histogram, error) {
+	if len(hs) == 0 {
+		return nil, fmt.Errorf("no histograms to sum")
+	}
+	sum := hs[0].Copy()
+	for i, h := range hs[1:] {
+		if err := checkSameLayout(sum.Schema, h.Schema, sum.CustomValues, h.CustomValues, sum.PositiveSpans, h.PositiveSpans, i+1); err != nil {
+			return nil, err
+		}
+		for j, b := range h.PositiveBuckets {
+			sum.PositiveBuckets[j] += b
+		}
+		sum.Count += h.Count
+		sum.Sum += h.Sum
+	}
+	return sum, nil
+}
+
+// SumFloatHistograms is the float counterpart of SumHistograms.
+func SumFloatHistograms(fhs []*histogram.FloatHistogram) (*histogram.FloatHistogram, error) {
+	if len(fhs) == 0 {
+		return nil, fmt.Errorf("no histograms to sum")
+	}
+	sum := fhs[0].Copy()
+	for i, fh := range fhs[1:] {
+		if err := checkSameLayout(sum.Schema, fh.Schema, sum.CustomValues, fh.CustomValues, sum.PositiveSpans, fh.PositiveSpans, i+1); err != nil {
+			return nil, err
+		}
+		for j, b := range fh.PositiveBuckets {
+			sum.PositiveBuckets[j] += b
+		}
+		sum.Count += fh.Count
+		sum.Sum += fh.Sum
+	}
+	return sum, nil
+}
+
+func checkSameLayout(schemaA, schemaB int32, customA, customB []float64, spansA, spansB []histogram.Span, idx int) error {
+	if schemaA != schemaB {
+		return fmt.Errorf("histogram %d has schema %d, want %d", idx, schemaB, schemaA)
+	}
+	if !slices.Equal(customA, customB) {
+		return fmt.Errorf("histogram %d has mismatched custom bucket boundaries", idx)
+	}
+	if !slices.Equal(spansA, spansB) {
+		return fmt.Errorf("histogram %d has mismatched bucket spans", idx)
+	}
+	return nil
+}
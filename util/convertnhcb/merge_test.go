@@ -0,0 +1,83 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+func TestUnionBounds(t *testing.T) {
+	inf := math.Inf(1)
+	require.Equal(t, []float64{1, 2, 3, inf}, UnionBounds([]float64{1, 3, inf}, []float64{2, 3, inf}))
+	require.Equal(t, []float64{1, 2}, UnionBounds([]float64{1, 2}, nil))
+	require.Equal(t, []float64{1, 2}, UnionBounds(nil, []float64{1, 2}))
+	require.Empty(t, UnionBounds(nil, nil))
+	// Identical sets union to themselves.
+	require.Equal(t, []float64{0.5, 1, inf}, UnionBounds([]float64{0.5, 1, inf}, []float64{0.5, 1, inf}))
+}
+
+func TestSumHistograms(t *testing.T) {
+	convert := func(t *testing.T) *histogram.Histogram {
+		h, _, err := Convert(IntegerHistogramTest, nil)
+		require.NoError(t, err)
+		return h
+	}
+
+	t.Run("three identical layouts", func(t *testing.T) {
+		hs := []*histogram.Histogram{convert(t), convert(t), convert(t)}
+		sum, err := SumHistograms(hs)
+		require.NoError(t, err)
+		require.NoError(t, sum.Validate())
+		require.Equal(t, uint64(75), sum.Count)
+		require.InDelta(t, 3*99.9, sum.Sum, 1e-9)
+		require.Equal(t, hs[0].CustomValues, sum.CustomValues)
+		// Per-bucket counts of {10, 5, 10, 0}, tripled and delta encoded.
+		require.Equal(t, []int64{30, -15, 15, -30}, sum.PositiveBuckets)
+		// The inputs are untouched.
+		require.Equal(t, uint64(25), hs[0].Count)
+	})
+
+	t.Run("mismatched layout errors", func(t *testing.T) {
+		other, _, err := Convert(TempHistogram{
+			BucketCounts: map[float64]float64{5: 1, 10: 2},
+			Count:        2,
+		}, nil)
+		require.NoError(t, err)
+		_, err = SumHistograms([]*histogram.Histogram{convert(t), other})
+		require.ErrorContains(t, err, "mismatched custom bucket boundaries")
+	})
+
+	t.Run("empty input errors", func(t *testing.T) {
+		_, err := SumHistograms(nil)
+		require.Error(t, err)
+	})
+}
+
+func TestSumFloatHistograms(t *testing.T) {
+	convert := func(t *testing.T) *histogram.FloatHistogram {
+		_, fh, err := Convert(IntegerHistogramTest, &Options{Scale: 0.5})
+		require.NoError(t, err)
+		return fh
+	}
+
+	sum, err := SumFloatHistograms([]*histogram.FloatHistogram{convert(t), convert(t)})
+	require.NoError(t, err)
+	require.Equal(t, 25.0, sum.Count)
+	require.Equal(t, []float64{10, 5, 10, 0}, sum.PositiveBuckets)
+}
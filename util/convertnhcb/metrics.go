@@ -0,0 +1,78 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the self-observability counters of the converter, for
+// deployments running it as a service. Wire them in via Options.Metrics;
+// library users who pass no Metrics pay nothing.
+type Metrics struct {
+	Conversions       prometheus.Counter
+	Errors            *prometheus.CounterVec
+	BucketsZeroFilled prometheus.Counter
+}
+
+// NewMetrics creates the converter's counters and registers them with reg,
+// which may be nil to leave registration to the caller.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Conversions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "convertnhcb_conversions_total",
+			Help: "Total number of successful classic histogram to NHCB conversions.",
+		}),
+		Errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "convertnhcb_errors_total",
+			Help: "Total number of failed conversions by reason.",
+		}, []string{"reason"}),
+		BucketsZeroFilled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "convertnhcb_buckets_zero_filled_total",
+			Help: "Total number of missing buckets that were assumed to have a cumulative count of 0.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.Conversions, m.Errors, m.BucketsZeroFilled)
+	}
+	return m
+}
+
+// observeConversion counts the outcome of one conversion.
+func (m *Metrics) observeConversion(err error) {
+	if err == nil {
+		m.Conversions.Inc()
+		return
+	}
+	m.Errors.WithLabelValues(errorReason(err)).Inc()
+}
+
+// errorReason maps a conversion error to the reason label value, based on the
+// package's error sentinels.
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNonMonotonic):
+		return "non_monotonic"
+	case errors.Is(err, ErrNoInfBucket):
+		return "no_inf_bucket"
+	case errors.Is(err, ErrNonFiniteCount):
+		return "non_finite_count"
+	case errors.Is(err, ErrDuplicateConflict):
+		return "duplicate_conflict"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+	opts := &Options{Metrics: m}
+
+	_, _, err := Convert(IntegerHistogramTest, opts)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(m.Conversions))
+
+	// A missing bucket is zero-filled and counted.
+	upperBounds, hBase, fhBase := processTestBounds(t, MissingBucketTestIntBounds)
+	h, _ := NewHistogramWithOptions(MissingBucketTestInt, upperBounds, hBase, fhBase, opts)
+	require.NotNil(t, h)
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(m.BucketsZeroFilled))
+
+	// A failed conversion counts under its reason.
+	opts.RequireInfBucket = true
+	th := TempHistogram{BucketCounts: map[float64]float64{1: 5}, Count: 5}
+	_, _, err = Convert(th, opts)
+	require.Error(t, err)
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(m.Conversions))
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(m.Errors.WithLabelValues("no_inf_bucket")))
+
+	th.BucketCounts[math.Inf(1)] = math.NaN()
+	_, _, err = Convert(th, opts)
+	require.Error(t, err)
+	require.Equal(t, 1.0, prom_testutil.ToFloat64(m.Errors.WithLabelValues("non_finite_count")))
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// WriteOpenMetrics renders a converted custom-buckets histogram back into the
+// classic histogram exposition text it is equivalent to: a TYPE line followed
+// by the cumulative _bucket series for every bound including +Inf, then _sum
+// and _count. The output is stable for identical inputs, which makes it
+// suitable for golden-file tests, and parses with the standard exposition
+// format parsers. The name is used as the metric family name; lbls carries
+// the extra labels every series gets.
+func WriteOpenMetrics(w io.Writer, name string, lbls labels.Labels, h *histogram.Histogram) error {
+	return WriteOpenMetricsWith(w, name, lbls, h, nil)
+}
+
+// WriteOpenMetricsWith is WriteOpenMetrics with a caller-supplied formatter
+// for the finite bucket bounds, as in FormatBoundsWith, so bridges can
+// control the le label format of the reconstructed series. A nil formatter
+// uses the canonical Prometheus formatting; +Inf always renders as "+Inf".
+func WriteOpenMetricsWith(w io.Writer, name string, lbls labels.Labels, h *histogram.Histogram, format func(float64) string) error {
+	if format == nil {
+		format = formatBound
+	}
+	if _, err := fmt.Fprintf(w, "# TYPE %s histogram\n", name); err != nil {
+		return err
+	}
+	var outer error
+	Buckets(h)(func(upperBound, count float64) bool {
+		le := "+Inf"
+		if !math.IsInf(upperBound, 1) {
+			le = format(upperBound)
+		}
+		_, err := fmt.Fprintf(w, "%s_bucket{%s} %s\n",
+			name, formatOMLabels(lbls, le), formatValue(count))
+		if err != nil {
+			outer = err
+			return false
+		}
+		return true
+	})
+	if outer != nil {
+		return outer
+	}
+	if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", name, omLabelBlock(lbls), formatValue(h.Sum)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_count%s %s\n", name, omLabelBlock(lbls), formatValue(float64(h.Count)))
+	return err
+}
+
+// omLabelBlock renders lbls as an exposition label block including braces, or
+// an empty string when there are no labels.
+func omLabelBlock(lbls labels.Labels) string {
+	if lbls.IsEmpty() {
+		return ""
+	}
+	return "{" + formatOMLabels(lbls, "") + "}"
+}
+
+// formatOMLabels renders lbls in exposition syntax, appending the le label
+// when a bound is given.
+func formatOMLabels(lbls labels.Labels, le string) string {
+	out := ""
+	lbls.Range(func(l labels.Label) {
+		if out != "" {
+			out += ","
+		}
+		out += l.Name + "=" + strconv.Quote(l.Value)
+	})
+	if le != "" {
+		if out != "" {
+			out += ","
+		}
+		out += labels.BucketLabel + "=" + strconv.Quote(le)
+	}
+	return out
+}
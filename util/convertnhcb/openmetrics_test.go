@@ -0,0 +1,71 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteOpenMetrics(&buf, "request_duration_seconds", labels.FromStrings("job", "test"), h))
+
+	want := `# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{job="test",le="1"} 10
+request_duration_seconds_bucket{job="test",le="2"} 15
+request_duration_seconds_bucket{job="test",le="3"} 25
+request_duration_seconds_bucket{job="test",le="+Inf"} 25
+request_duration_seconds_sum{job="test"} 99.9
+request_duration_seconds_count{job="test"} 25
+`
+	require.Equal(t, want, buf.String())
+
+	// The output parses with the standard exposition parser.
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	mf := families["request_duration_seconds"]
+	require.NotNil(t, mf)
+	hist := mf.GetMetric()[0].GetHistogram()
+	require.Equal(t, uint64(25), hist.GetSampleCount())
+	require.Equal(t, 99.9, hist.GetSampleSum())
+	require.Len(t, hist.GetBucket(), 4)
+
+	t.Run("no extra labels", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, WriteOpenMetrics(&buf, "m", labels.EmptyLabels(), h))
+		require.Contains(t, buf.String(), "m_bucket{le=\"1\"} 10\n")
+		require.Contains(t, buf.String(), "m_count 25\n")
+	})
+
+	t.Run("custom bound formatter", func(t *testing.T) {
+		var buf bytes.Buffer
+		fixed := func(b float64) string { return fmt.Sprintf("%.2f", b) }
+		require.NoError(t, WriteOpenMetricsWith(&buf, "m", labels.EmptyLabels(), h, fixed))
+		require.Contains(t, buf.String(), "m_bucket{le=\"1.00\"} 10\n")
+		require.Contains(t, buf.String(), "m_bucket{le=\"+Inf\"} 25\n")
+	})
+}
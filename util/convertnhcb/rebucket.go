@@ -0,0 +1,88 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// Rebucket folds a converted custom-buckets histogram onto a coarser bound
+// set, summing the counts of the dropped finer buckets into the next coarser
+// one. Every target bound must exist among the source bounds (+Inf counts as
+// existing, since it is implicit in every histogram); downsampling onto
+// unrelated bounds would have to split buckets, which the data cannot
+// support. Count and Sum are preserved exactly.
+func Rebucket(h *histogram.Histogram, targetBounds []float64) (*histogram.Histogram, error) {
+	cumulative := map[float64]float64{}
+	Buckets(h)(func(upperBound, count float64) bool {
+		cumulative[upperBound] = count
+		return true
+	})
+	th := NewTempHistogram()
+	for _, bound := range targetBounds {
+		c, ok := cumulative[bound]
+		if !ok {
+			return nil, fmt.Errorf("target bound %g does not exist among the source bounds", bound)
+		}
+		th.BucketCounts[bound] = c
+	}
+	th.Count = float64(h.Count)
+	th.SetSum(h.Sum)
+	out, _, err := Convert(th, nil)
+	return out, err
+}
+
+// Refine is the inverse of Rebucket: it aligns a converted custom-buckets
+// histogram onto a finer bound set that is a superset of its own, inserting
+// the new bounds as empty buckets at the right positions. Observations stay
+// in the bucket they were in, and Count and Sum are preserved exactly, so the
+// result can be compared or aggregated with histograms that carry the finer
+// layout. A target missing one of the source's bounds would require merging
+// buckets instead, which is Rebucket's job, and is an error here.
+func Refine(h *histogram.Histogram, targetBounds []float64) (*histogram.Histogram, error) {
+	target := make(map[float64]struct{}, len(targetBounds))
+	for _, bound := range targetBounds {
+		target[bound] = struct{}{}
+	}
+	for _, bound := range h.CustomValues {
+		if _, ok := target[bound]; !ok {
+			return nil, fmt.Errorf("target bounds do not include source bound %g", bound)
+		}
+	}
+	cumulative := map[float64]float64{}
+	Buckets(h)(func(upperBound, count float64) bool {
+		cumulative[upperBound] = count
+		return true
+	})
+	sorted := make([]float64, len(targetBounds))
+	copy(sorted, targetBounds)
+	sort.Float64s(sorted)
+	th := NewTempHistogramSized(len(sorted))
+	// A bound new to the layout keeps the cumulative count of the source
+	// bound below it, which is exactly an empty bucket.
+	running := 0.0
+	for _, bound := range sorted {
+		if c, ok := cumulative[bound]; ok {
+			running = c
+		}
+		th.BucketCounts[bound] = running
+	}
+	th.Count = float64(h.Count)
+	th.SetSum(h.Sum)
+	out, _, err := Convert(th, nil)
+	return out, err
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebucket(t *testing.T) {
+	h, _, err := Convert(IntegerHistogramTest, nil)
+	require.NoError(t, err)
+
+	coarse, err := Rebucket(h, []float64{2, math.Inf(1)})
+	require.NoError(t, err)
+	require.Equal(t, []float64{2}, coarse.CustomValues)
+	require.Equal(t, h.Count, coarse.Count)
+	require.Equal(t, h.Sum, coarse.Sum)
+
+	type pair struct{ le, count float64 }
+	var pairs []pair
+	Buckets(coarse)(func(upperBound, count float64) bool {
+		pairs = append(pairs, pair{upperBound, count})
+		return true
+	})
+	// The le=1 bucket folded into le=2, the le=3 one into +Inf.
+	require.Equal(t, []pair{{2, 15}, {math.Inf(1), 25}}, pairs)
+
+	t.Run("unknown target bound errors", func(t *testing.T) {
+		_, err := Rebucket(h, []float64{2.5, math.Inf(1)})
+		require.Error(t, err)
+	})
+}
+
+func TestRefine(t *testing.T) {
+	th := TempHistogram{
+		BucketCounts: map[float64]float64{2: 3, math.Inf(1): 5},
+		Count:        5,
+	}
+	th.SetSum(12.5)
+	h, _, err := Convert(th, nil)
+	require.NoError(t, err)
+
+	fine, err := Refine(h, []float64{1, 2, 3, math.Inf(1)})
+	require.NoError(t, err)
+	require.Equal(t, []float64{1, 2, 3}, fine.CustomValues)
+	require.Equal(t, h.Count, fine.Count)
+	require.Equal(t, h.Sum, fine.Sum)
+
+	type pair struct{ le, count float64 }
+	var pairs []pair
+	Buckets(fine)(func(upperBound, count float64) bool {
+		pairs = append(pairs, pair{upperBound, count})
+		return true
+	})
+	// The new le=1 and le=3 buckets are empty; the original counts stay in
+	// their buckets.
+	require.Equal(t, []pair{{1, 0}, {2, 3}, {3, 3}, {math.Inf(1), 5}}, pairs)
+
+	t.Run("target missing a source bound errors", func(t *testing.T) {
+		_, err := Refine(h, []float64{1, 3, math.Inf(1)})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"unsafe"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// EstimateSize returns the approximate heap footprint in bytes of a converted
+// custom-buckets histogram: the struct itself (including the slice headers)
+// plus the backing arrays of its spans, buckets and custom values. It counts
+// lengths rather than capacities and ignores allocator overhead, so it is a
+// planning estimate, not an exact accounting. Note that interned CustomValues
+// are shared between histograms but still counted in full for each.
+func EstimateSize(h *histogram.Histogram) int {
+	size := int(unsafe.Sizeof(*h))
+	size += len(h.PositiveSpans) * int(unsafe.Sizeof(histogram.Span{}))
+	size += len(h.NegativeSpans) * int(unsafe.Sizeof(histogram.Span{}))
+	size += (len(h.PositiveBuckets) + len(h.NegativeBuckets)) * 8
+	size += len(h.CustomValues) * 8
+	return size
+}
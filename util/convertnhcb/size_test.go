@@ -0,0 +1,43 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateSize(t *testing.T) {
+	build := func(buckets int) int {
+		th := NewTempHistogram()
+		for i := 0; i < buckets-1; i++ {
+			th.BucketCounts[float64(i+1)] = float64(i + 1)
+		}
+		th.BucketCounts[math.Inf(1)] = float64(buckets)
+		h, _, err := Convert(th, nil)
+		require.NoError(t, err)
+		return EstimateSize(h)
+	}
+
+	small := build(4)
+	large := build(64)
+	require.Greater(t, small, 0)
+	// Each extra bucket costs one delta and one custom value, 16 bytes.
+	require.Equal(t, 60*16, large-small)
+
+	// Identical inputs report identical estimates.
+	require.Equal(t, small, build(4))
+}
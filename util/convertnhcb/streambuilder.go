@@ -0,0 +1,223 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Metadata carries the metric family metadata of a classic histogram so that
+// conversion does not drop it. OpenMetrics exposes it as the UNIT and HELP
+// lines preceding the samples.
+type Metadata struct {
+	Unit string
+	Help string
+}
+
+// ConvertedHistogram is one native histogram with custom buckets emitted by a
+// StreamBuilder flush. Exactly one of H and FH is non-nil. Metadata is the
+// zero value unless it was provided via SetMetadata.
+type ConvertedHistogram struct {
+	Labels    labels.Labels
+	Timestamp int64
+	H         *histogram.Histogram
+	FH        *histogram.FloatHistogram
+	Metadata  Metadata
+}
+
+// StreamBuilder accumulates classic histogram component series straight from
+// a parsed sample stream and converts each recognized histogram to an NHCB on
+// Flush. It sits one level above TempHistogram: callers feed it every sample
+// and it does the suffix detection, grouping by base labels, and conversion
+// that scrape-style loops would otherwise reimplement.
+type StreamBuilder struct {
+	opts   *Options
+	series map[uint64]*streamSeries
+	// order preserves first-seen order of the histograms for deterministic
+	// flush output.
+	order []uint64
+	// metadata maps a base metric name to the metadata provided for its
+	// family, attached to every flushed histogram of that name.
+	metadata map[string]Metadata
+}
+
+type streamSeries struct {
+	baseLabels labels.Labels
+	histogram  TempHistogram
+	timestamp  int64
+}
+
+// NewStreamBuilder returns a StreamBuilder converting with the given options,
+// which may be nil for defaults.
+func NewStreamBuilder(opts *Options) *StreamBuilder {
+	return &StreamBuilder{
+		opts:   opts,
+		series: map[uint64]*streamSeries{},
+	}
+}
+
+// Add routes one parsed sample into the builder. It reports whether the
+// sample was recognized as a classic histogram component (_bucket, _sum,
+// _count or _created series); unrecognized samples are left for the caller to
+// handle. A bucket series with a malformed le label is skipped with a
+// warning, or is an error under Options.StrictLe.
+func (b *StreamBuilder) Add(metricName string, lbls labels.Labels, t int64, value float64) (bool, error) {
+	if baseName, ok := GetCreatedMetricBaseName(metricName); ok {
+		b.seriesFor(lbls, baseName).histogram.CreatedTimestamp = int64(value)
+		return true, nil
+	}
+	suffixes := b.suffixes()
+	baseName := suffixes.BaseName(metricName)
+	if baseName == metricName {
+		return false, nil
+	}
+	s := b.seriesFor(lbls, baseName)
+	s.timestamp = t
+	switch {
+	case strings.HasSuffix(metricName, suffixes.Bucket):
+		le, err := ParseUpperBound(lbls.Get(b.opts.bucketLabel()))
+		if err != nil {
+			if b.opts != nil && b.opts.StrictLe {
+				return true, fmt.Errorf("series %s: %w", lbls.String(), err)
+			}
+			b.opts.warn("series %s: skipping bucket: %s", lbls.String(), err)
+			return true, nil
+		}
+		s.histogram.BucketCounts[le] = value
+	case strings.HasSuffix(metricName, suffixes.Sum):
+		s.histogram.SetSum(value)
+	case strings.HasSuffix(metricName, suffixes.Count):
+		s.histogram.Count = value
+	}
+	return true, nil
+}
+
+// SetMetadata records the metadata of a classic histogram family so Flush can
+// attach it to the converted histograms. The name may be either the base
+// metric name or any component series name; the component suffix is stripped.
+// Metadata is reset together with the samples on Flush.
+func (b *StreamBuilder) SetMetadata(metricName string, md Metadata) {
+	if baseName, ok := GetCreatedMetricBaseName(metricName); ok {
+		metricName = baseName
+	}
+	if b.metadata == nil {
+		b.metadata = map[string]Metadata{}
+	}
+	b.metadata[b.suffixes().BaseName(metricName)] = md
+}
+
+// suffixes returns the configured suffix set, defaulting to the standard
+// Prometheus one.
+func (b *StreamBuilder) suffixes() SuffixSet {
+	if b.opts != nil && b.opts.Suffixes != nil {
+		return *b.opts.Suffixes
+	}
+	return DefaultSuffixSet()
+}
+
+// seriesFor returns the accumulator for the sample's histogram, creating it
+// on first sight of any component series.
+func (b *StreamBuilder) seriesFor(lbls labels.Labels, baseName string) *streamSeries {
+	builder := labels.NewBuilder(lbls).
+		Set(labels.MetricName, baseName).
+		Del(b.opts.bucketLabel(), model.QuantileLabel)
+	base := builder.Labels()
+	// The key must not include the source annotation: the components of one
+	// histogram carry different original names and still have to group
+	// together.
+	key := base.Hash()
+	s, ok := b.series[key]
+	if !ok {
+		if b.opts != nil && b.opts.AnnotateSource {
+			sourceLabel := b.opts.SourceLabel
+			if sourceLabel == "" {
+				sourceLabel = DefaultSourceLabel
+			}
+			base = builder.Set(sourceLabel, lbls.Get(labels.MetricName)).Labels()
+		}
+		s = &streamSeries{
+			baseLabels: base,
+			histogram:  NewTempHistogram(),
+		}
+		b.series[key] = s
+		b.order = append(b.order, key)
+	}
+	return s
+}
+
+// LabeledClassicSample is one classic histogram component sample with its
+// full label set, the batch-input shape of ConvertAll.
+type LabeledClassicSample struct {
+	Labels    labels.Labels
+	Timestamp int64
+	Value     float64
+}
+
+// ConvertedSeries is one converted histogram keyed by its base label set, as
+// returned by ConvertAll.
+type ConvertedSeries = ConvertedHistogram
+
+// ConvertAll converts a batch of classic histogram samples in one call,
+// grouping them by their canonical base label set (component suffix and
+// bucket label stripped) so that label variants of the same metric become
+// independent histograms. The result is keyed by the hash of the base labels.
+// Samples that are not histogram components are ignored.
+func ConvertAll(samples []LabeledClassicSample) (map[uint64]ConvertedSeries, error) {
+	b := NewStreamBuilder(nil)
+	for _, s := range samples {
+		if _, err := b.Add(s.Labels.Get(labels.MetricName), s.Labels, s.Timestamp, s.Value); err != nil {
+			return nil, err
+		}
+	}
+	converted, err := b.Flush()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint64]ConvertedSeries, len(converted))
+	for _, c := range converted {
+		out[c.Labels.Hash()] = c
+	}
+	return out, nil
+}
+
+// Flush converts every accumulated histogram and resets the builder for the
+// next scrape. Histograms are emitted in the order their first component was
+// seen. Conversion failures abort the flush.
+func (b *StreamBuilder) Flush() ([]ConvertedHistogram, error) {
+	out := make([]ConvertedHistogram, 0, len(b.order))
+	for _, key := range b.order {
+		s := b.series[key]
+		h, fh, err := Convert(s.histogram, b.opts)
+		if err != nil {
+			return nil, fmt.Errorf("converting %s: %w", s.baseLabels.String(), err)
+		}
+		out = append(out, ConvertedHistogram{
+			Labels:    s.baseLabels,
+			Timestamp: s.timestamp,
+			H:         h,
+			FH:        fh,
+			Metadata:  b.metadata[s.baseLabels.Get(labels.MetricName)],
+		})
+	}
+	b.series = map[uint64]*streamSeries{}
+	b.order = b.order[:0]
+	b.metadata = nil
+	return out, nil
+}
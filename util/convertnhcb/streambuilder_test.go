@@ -0,0 +1,306 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+func TestStreamBuilder(t *testing.T) {
+	b := NewStreamBuilder(nil)
+
+	// Two histograms' component series, deliberately interleaved the way a
+	// scrape would never quite deliver them.
+	samples := []struct {
+		name  string
+		le    string
+		value float64
+	}{
+		{name: "a_bucket", le: "1", value: 10},
+		{name: "b_bucket", le: "0.5", value: 2.5},
+		{name: "a_bucket", le: "+Inf", value: 25},
+		{name: "b_sum", value: 3.5},
+		{name: "a_sum", value: 99.9},
+		{name: "b_bucket", le: "+Inf", value: 4.5},
+		{name: "a_count", value: 25},
+		{name: "b_count", value: 4.5},
+	}
+	for _, s := range samples {
+		lbls := labels.FromStrings(labels.MetricName, s.name, "job", "test")
+		if s.le != "" {
+			lbls = labels.FromStrings(labels.MetricName, s.name, labels.BucketLabel, s.le, "job", "test")
+		}
+		recognized, err := b.Add(s.name, lbls, 1000, s.value)
+		require.NoError(t, err)
+		require.True(t, recognized)
+	}
+
+	// A plain gauge is not picked up.
+	recognized, err := b.Add("up", labels.FromStrings(labels.MetricName, "up", "job", "test"), 1000, 1)
+	require.NoError(t, err)
+	require.False(t, recognized)
+
+	converted, err := b.Flush()
+	require.NoError(t, err)
+	require.Len(t, converted, 2)
+
+	require.Equal(t, labels.FromStrings(labels.MetricName, "a", "job", "test"), converted[0].Labels)
+	require.Equal(t, int64(1000), converted[0].Timestamp)
+	require.NotNil(t, converted[0].H)
+	require.Equal(t, uint64(25), converted[0].H.Count)
+	require.Equal(t, 99.9, converted[0].H.Sum)
+	require.Equal(t, []float64{1}, converted[0].H.CustomValues)
+
+	require.Equal(t, labels.FromStrings(labels.MetricName, "b", "job", "test"), converted[1].Labels)
+	require.NotNil(t, converted[1].FH)
+	require.Equal(t, 4.5, converted[1].FH.Count)
+	require.Equal(t, 3.5, converted[1].FH.Sum)
+	require.Equal(t, []float64{0.5}, converted[1].FH.CustomValues)
+
+	// Flush resets the builder.
+	converted, err = b.Flush()
+	require.NoError(t, err)
+	require.Empty(t, converted)
+}
+
+func TestSuffixSet(t *testing.T) {
+	t.Run("default matches GetHistogramMetricBaseName", func(t *testing.T) {
+		for _, name := range []string{"m_bucket", "m_sum", "m_count", "m", "m_created"} {
+			require.Equal(t, GetHistogramMetricBaseName(name), DefaultSuffixSet().BaseName(name))
+		}
+	})
+
+	t.Run("custom suffixes", func(t *testing.T) {
+		set := SuffixSet{Bucket: "_le", Sum: "_total", Count: "_n"}
+		require.Equal(t, "m", set.BaseName("m_le"))
+		require.Equal(t, "m", set.BaseName("m_total"))
+		require.Equal(t, "m", set.BaseName("m_n"))
+		// The standard suffixes are not special to a custom set.
+		require.Equal(t, "m_bucket", set.BaseName("m_bucket"))
+	})
+}
+
+func TestStreamBuilderCustomSuffixes(t *testing.T) {
+	set := SuffixSet{Bucket: "_le", Sum: "_total", Count: "_n"}
+	b := NewStreamBuilder(&Options{Suffixes: &set})
+
+	for _, s := range []struct {
+		name  string
+		le    string
+		value float64
+	}{
+		{name: "a_le", le: "1", value: 10},
+		{name: "a_le", le: "+Inf", value: 25},
+		{name: "a_total", value: 99.9},
+		{name: "a_n", value: 25},
+	} {
+		lbls := labels.FromStrings(labels.MetricName, s.name, "job", "test")
+		if s.le != "" {
+			lbls = labels.FromStrings(labels.MetricName, s.name, labels.BucketLabel, s.le, "job", "test")
+		}
+		recognized, err := b.Add(s.name, lbls, 1000, s.value)
+		require.NoError(t, err)
+		require.True(t, recognized)
+	}
+
+	converted, err := b.Flush()
+	require.NoError(t, err)
+	require.Len(t, converted, 1)
+	require.Equal(t, labels.FromStrings(labels.MetricName, "a", "job", "test"), converted[0].Labels)
+	require.Equal(t, uint64(25), converted[0].H.Count)
+}
+
+func TestStreamBuilderBucketLabel(t *testing.T) {
+	b := NewStreamBuilder(&Options{BucketLabel: "upper_bound"})
+
+	for _, s := range []struct {
+		name  string
+		bound string
+		value float64
+	}{
+		{name: "a_bucket", bound: "1", value: 10},
+		{name: "a_bucket", bound: "+Inf", value: 25},
+		{name: "a_sum", value: 99.9},
+		{name: "a_count", value: 25},
+	} {
+		lbls := labels.FromStrings(labels.MetricName, s.name, "job", "test")
+		if s.bound != "" {
+			lbls = labels.FromStrings(labels.MetricName, s.name, "upper_bound", s.bound, "job", "test")
+		}
+		recognized, err := b.Add(s.name, lbls, 1000, s.value)
+		require.NoError(t, err)
+		require.True(t, recognized)
+	}
+
+	converted, err := b.Flush()
+	require.NoError(t, err)
+	require.Len(t, converted, 1)
+	// The configured label is stripped from the base label set.
+	require.Equal(t, labels.FromStrings(labels.MetricName, "a", "job", "test"), converted[0].Labels)
+	require.Equal(t, uint64(25), converted[0].H.Count)
+	require.Equal(t, []float64{1}, converted[0].H.CustomValues)
+}
+
+func TestStreamBuilderAnnotateSource(t *testing.T) {
+	feed := func(t *testing.T, b *StreamBuilder) []ConvertedHistogram {
+		t.Helper()
+		for _, s := range []struct {
+			name  string
+			le    string
+			value float64
+		}{
+			{name: "a_bucket", le: "1", value: 10},
+			{name: "a_bucket", le: "+Inf", value: 25},
+			{name: "a_sum", value: 99.9},
+			{name: "a_count", value: 25},
+		} {
+			lbls := labels.FromStrings(labels.MetricName, s.name, "job", "test")
+			if s.le != "" {
+				lbls = labels.FromStrings(labels.MetricName, s.name, labels.BucketLabel, s.le, "job", "test")
+			}
+			_, err := b.Add(s.name, lbls, 1000, s.value)
+			require.NoError(t, err)
+		}
+		converted, err := b.Flush()
+		require.NoError(t, err)
+		require.Len(t, converted, 1)
+		return converted
+	}
+
+	t.Run("enabled records the pre-strip name", func(t *testing.T) {
+		converted := feed(t, NewStreamBuilder(&Options{AnnotateSource: true}))
+		require.Equal(t,
+			labels.FromStrings(labels.MetricName, "a", DefaultSourceLabel, "a_bucket", "job", "test"),
+			converted[0].Labels)
+	})
+
+	t.Run("custom label name", func(t *testing.T) {
+		converted := feed(t, NewStreamBuilder(&Options{AnnotateSource: true, SourceLabel: "__classic_name__"}))
+		require.Equal(t, "a_bucket", converted[0].Labels.Get("__classic_name__"))
+	})
+
+	t.Run("disabled leaves labels untouched", func(t *testing.T) {
+		converted := feed(t, NewStreamBuilder(nil))
+		require.Equal(t,
+			labels.FromStrings(labels.MetricName, "a", "job", "test"),
+			converted[0].Labels)
+	})
+}
+
+func TestConvertAll(t *testing.T) {
+	var samples []LabeledClassicSample
+	// The same metric for two endpoints, interleaved.
+	for _, endpoint := range []string{"/api", "/health"} {
+		scale := 1.0
+		if endpoint == "/health" {
+			scale = 2
+		}
+		for _, s := range []struct {
+			suffix string
+			le     string
+			value  float64
+		}{
+			{suffix: "_bucket", le: "1", value: 10},
+			{suffix: "_bucket", le: "+Inf", value: 25},
+			{suffix: "_sum", value: 99.9},
+			{suffix: "_count", value: 25},
+		} {
+			lbls := labels.FromStrings(labels.MetricName, "a"+s.suffix, "endpoint", endpoint)
+			if s.le != "" {
+				lbls = labels.FromStrings(labels.MetricName, "a"+s.suffix, labels.BucketLabel, s.le, "endpoint", endpoint)
+			}
+			samples = append(samples, LabeledClassicSample{Labels: lbls, Timestamp: 1000, Value: s.value * scale})
+		}
+	}
+
+	converted, err := ConvertAll(samples)
+	require.NoError(t, err)
+	require.Len(t, converted, 2)
+
+	api := labels.FromStrings(labels.MetricName, "a", "endpoint", "/api")
+	health := labels.FromStrings(labels.MetricName, "a", "endpoint", "/health")
+	require.Equal(t, api, converted[api.Hash()].Labels)
+	require.Equal(t, uint64(25), converted[api.Hash()].H.Count)
+	require.Equal(t, health, converted[health.Hash()].Labels)
+	require.Equal(t, uint64(50), converted[health.Hash()].H.Count)
+}
+
+func TestStreamBuilderMetadata(t *testing.T) {
+	b := NewStreamBuilder(nil)
+
+	feed := func(name string) {
+		for _, s := range []struct {
+			suffix string
+			le     string
+			value  float64
+		}{
+			{suffix: "_bucket", le: "1", value: 10},
+			{suffix: "_bucket", le: "+Inf", value: 25},
+			{suffix: "_sum", value: 99.9},
+			{suffix: "_count", value: 25},
+		} {
+			lbls := labels.FromStrings(labels.MetricName, name+s.suffix, "job", "test")
+			if s.le != "" {
+				lbls = labels.FromStrings(labels.MetricName, name+s.suffix, labels.BucketLabel, s.le, "job", "test")
+			}
+			_, err := b.Add(name+s.suffix, lbls, 1000, s.value)
+			require.NoError(t, err)
+		}
+	}
+	feed("a")
+	feed("b")
+	// Metadata is accepted under a component name as well as the base name.
+	b.SetMetadata("a_bucket", Metadata{Unit: "seconds", Help: "Request duration."})
+
+	converted, err := b.Flush()
+	require.NoError(t, err)
+	require.Len(t, converted, 2)
+	require.Equal(t, Metadata{Unit: "seconds", Help: "Request duration."}, converted[0].Metadata)
+	// Absent metadata stays empty.
+	require.Equal(t, Metadata{}, converted[1].Metadata)
+}
+
+func TestStreamBuilderBadLe(t *testing.T) {
+	lbls := labels.FromStrings(labels.MetricName, "a_bucket", labels.BucketLabel, "bogus", "job", "test")
+
+	t.Run("strict mode errors", func(t *testing.T) {
+		b := NewStreamBuilder(&Options{StrictLe: true})
+		recognized, err := b.Add("a_bucket", lbls, 0, 1)
+		require.True(t, recognized)
+		require.Error(t, err)
+	})
+
+	t.Run("lenient mode skips with a warning", func(t *testing.T) {
+		var warnings []ConvertWarning
+		b := NewStreamBuilder(&Options{Warnings: &warnings})
+		recognized, err := b.Add("a_bucket", lbls, 0, 1)
+		require.True(t, recognized)
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+
+		// The bad bucket did not make it into the accumulator.
+		good := labels.FromStrings(labels.MetricName, "a_bucket", labels.BucketLabel, "+Inf", "job", "test")
+		_, err = b.Add("a_bucket", good, 0, 5)
+		require.NoError(t, err)
+		converted, err := b.Flush()
+		require.NoError(t, err)
+		require.Len(t, converted, 1)
+		require.Equal(t, uint64(5), converted[0].H.Count)
+		require.Empty(t, converted[0].H.CustomValues)
+	})
+}
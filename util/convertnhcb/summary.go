@@ -0,0 +1,87 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/prometheus/prometheus/model/histogram"
+)
+
+// QuantileSample is one quantile series of a classic summary, e.g.
+// {quantile="0.5"} with its observed value.
+type QuantileSample struct {
+	Quantile float64
+	Value    float64
+}
+
+// SummaryToNHCB approximates a classic summary as a custom-buckets histogram
+// by treating the quantile values as bucket boundaries and the quantile
+// fractions of the total count as cumulative bucket counts, with the summary's
+// sum and count carried over. The approximation is inherently lossy: the
+// distribution inside each synthetic bucket is unknown and the cumulative
+// counts are rounded to integers, so quantiles recomputed from the result only
+// roughly match the input. It exists for unified storage of summary-only
+// targets, not for precision work. Quantiles sharing the same observed value
+// fold into one bucket.
+func SummaryToNHCB(samples []QuantileSample, sum, count float64) (*histogram.Histogram, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("summary has no quantile samples")
+	}
+	if count != math.Round(count) || math.IsNaN(count) || math.IsInf(count, 0) {
+		return nil, fmt.Errorf("summary count %g is not an integer", count)
+	}
+	sorted := make([]QuantileSample, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Quantile < sorted[j].Quantile })
+
+	th := NewTempHistogram()
+	prevValue := math.Inf(-1)
+	for _, s := range sorted {
+		if s.Quantile < 0 || s.Quantile > 1 || math.IsNaN(s.Quantile) {
+			return nil, fmt.Errorf("quantile %g is outside [0, 1]", s.Quantile)
+		}
+		// client_golang exposes the quantiles of an empty summary as NaN
+		// values; they carry no boundary information and are skipped. They
+		// must not fall through to the checks below: NaN compares false
+		// against everything, so it would slip past the monotonicity check
+		// and end up as a bucket bound.
+		if math.IsNaN(s.Value) {
+			continue
+		}
+		if math.IsInf(s.Value, 0) {
+			return nil, fmt.Errorf("value %g at quantile %g is not a usable bucket boundary", s.Value, s.Quantile)
+		}
+		if s.Value < prevValue {
+			return nil, fmt.Errorf("value %g at quantile %g is below the previous quantile's value %g: %w", s.Value, s.Quantile, prevValue, ErrNonMonotonic)
+		}
+		prevValue = s.Value
+		cum := math.Round(s.Quantile * count)
+		if existing, ok := th.BucketCounts[s.Value]; !ok || cum > existing {
+			th.BucketCounts[s.Value] = cum
+		}
+	}
+	th.BucketCounts[math.Inf(1)] = count
+	th.Count = count
+	th.SetSum(sum)
+
+	h, _, err := Convert(th, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convertnhcb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryToNHCB(t *testing.T) {
+	samples := []QuantileSample{
+		{Quantile: 0.9, Value: 2.5},
+		{Quantile: 0.5, Value: 0.8},
+		{Quantile: 0.99, Value: 4},
+	}
+	h, err := SummaryToNHCB(samples, 123.4, 100)
+	require.NoError(t, err)
+	require.NoError(t, h.Validate())
+	require.Equal(t, uint64(100), h.Count)
+	require.Equal(t, 123.4, h.Sum)
+	require.Equal(t, []float64{0.8, 2.5, 4}, h.CustomValues)
+
+	// The cumulative counts are monotone and follow the quantile fractions.
+	var cum []float64
+	Buckets(h)(func(upperBound, count float64) bool {
+		cum = append(cum, count)
+		return true
+	})
+	require.Equal(t, []float64{50, 90, 99, 100}, cum)
+
+	t.Run("non-monotone quantile values error", func(t *testing.T) {
+		_, err := SummaryToNHCB([]QuantileSample{
+			{Quantile: 0.5, Value: 2},
+			{Quantile: 0.9, Value: 1},
+		}, 1, 10)
+		require.ErrorIs(t, err, ErrNonMonotonic)
+	})
+
+	t.Run("no samples", func(t *testing.T) {
+		_, err := SummaryToNHCB(nil, 0, 0)
+		require.Error(t, err)
+	})
+
+	t.Run("duplicate quantile values fold", func(t *testing.T) {
+		h, err := SummaryToNHCB([]QuantileSample{
+			{Quantile: 0.5, Value: 1},
+			{Quantile: 0.9, Value: 1},
+		}, 10, 100)
+		require.NoError(t, err)
+		require.Equal(t, []float64{1}, h.CustomValues)
+	})
+
+	t.Run("empty summary's NaN quantile values are skipped", func(t *testing.T) {
+		// client_golang exposes the quantiles of an empty summary as NaN
+		// values; they must not become bucket bounds.
+		h, err := SummaryToNHCB([]QuantileSample{
+			{Quantile: 0.5, Value: math.NaN()},
+			{Quantile: 0.9, Value: math.NaN()},
+		}, 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, h.Validate())
+		require.Empty(t, h.CustomValues)
+		require.Equal(t, uint64(0), h.Count)
+	})
+
+	t.Run("infinite quantile values error", func(t *testing.T) {
+		for _, v := range []float64{math.Inf(1), math.Inf(-1)} {
+			_, err := SummaryToNHCB([]QuantileSample{
+				{Quantile: 0.5, Value: 1},
+				{Quantile: 0.9, Value: v},
+			}, 1, 10)
+			require.Error(t, err, "value=%g", v)
+		}
+	})
+}